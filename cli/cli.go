@@ -2,6 +2,7 @@ package cli
 
 import (
 	"bufio"
+	"errors"
 	"fmt"
 	"os"
 	"strings"
@@ -36,7 +37,14 @@ func (c *CLI) printHelp() {
 		SET <key> <val> Insert a key-value pair into the DB
 		DEL <key>       Remove a key-value pair from the DB
 		GET <key>       Retrieve the value for a key from the DB
-		EXIT            Terminate this session
+		EXPLAIN GET <key>
+		                Retrieve the value for a key and show how it was found
+		FLUSH           Flush all memtables to sstables in the background
+		COMPACT [start end]
+		                Compact a key range, or the whole DB, in the background
+		METRICS         Print the metrics of the DB
+		VERIFY          Check the sstables and the manifest for corruption
+		EXIT            Close the DB and terminate this session
 		`)
 }
 
@@ -61,18 +69,41 @@ func (c *CLI) processInput(line string) {
 		c.processDeleteCommand(fields[1:])
 	case "get":
 		c.processGetCommand(fields[1:])
+	case "flush":
+		c.processFlushCommand(fields[1:])
+	case "compact":
+		c.processCompactCommand(fields[1:])
+	case "explain":
+		c.processExplainCommand(fields[1:])
+	case "metrics":
+		c.processMetricsCommand(fields[1:])
+	case "verify":
+		c.processVerifyCommand(fields[1:])
 	case "exit":
-		os.Exit(0)
+		c.Exit()
 	}
 	c.printPrompt()
 }
 
+// Exit closes the DB, which flushes its memtables, and terminates the
+// process.
+func (c *CLI) Exit() {
+	if err := c.db.Close(); err != nil {
+		fmt.Println(err)
+		os.Exit(1)
+	}
+	os.Exit(0)
+}
+
 func (c *CLI) processSetCommand(args []string) {
 	if len(args) != 2 {
 		fmt.Println("Usage: SET <key> <value>")
 		return
 	}
-	c.db.Set([]byte(args[0]), []byte(args[1]))
+	if err := c.db.Set([]byte(args[0]), []byte(args[1])); err != nil {
+		fmt.Println(err)
+		return
+	}
 	fmt.Println("OK.")
 }
 
@@ -81,7 +112,10 @@ func (c *CLI) processDeleteCommand(args []string) {
 		fmt.Println("Usage: DEL <key>")
 		return
 	}
-	c.db.Delete([]byte(args[0]))
+	if err := c.db.Delete([]byte(args[0])); err != nil {
+		fmt.Println(err)
+		return
+	}
 	fmt.Println("OK.")
 }
 
@@ -91,10 +125,86 @@ func (c *CLI) processGetCommand(args []string) {
 		return
 	}
 	val, err := c.db.Get([]byte(args[0]))
+	switch {
+	case errors.Is(err, db.ErrNotFound):
+		fmt.Println("Key not found.")
+	case err != nil:
+		fmt.Println(err)
+	default:
+		fmt.Println(string(val))
+	}
+}
 
-	if err != nil {
+func (c *CLI) processExplainCommand(args []string) {
+	if len(args) != 2 || strings.ToLower(args[0]) != "get" {
+		fmt.Println("Usage: EXPLAIN GET <key>")
+		return
+	}
+	val, trace, err := c.db.GetWithTrace([]byte(args[1]))
+	fmt.Print(trace)
+	switch {
+	case errors.Is(err, db.ErrNotFound):
 		fmt.Println("Key not found.")
+	case err != nil:
+		fmt.Println(err)
+	default:
+		fmt.Println(string(val))
+	}
+}
+
+func (c *CLI) processFlushCommand(args []string) {
+	if len(args) != 0 {
+		fmt.Println("Usage: FLUSH")
 		return
 	}
-	fmt.Println(string(val))
+	c.reportJob(c.db.Flush())
+	fmt.Println("Flush started.")
+}
+
+func (c *CLI) processCompactCommand(args []string) {
+	var start, end []byte
+	switch len(args) {
+	case 0:
+	case 2:
+		start, end = []byte(args[0]), []byte(args[1])
+	default:
+		fmt.Println("Usage: COMPACT [start end]")
+		return
+	}
+	c.reportJob(c.db.CompactRange(start, end))
+	fmt.Println("Compaction started.")
+}
+
+func (c *CLI) processMetricsCommand(args []string) {
+	if len(args) != 0 {
+		fmt.Println("Usage: METRICS")
+		return
+	}
+	fmt.Print(c.db.Metrics())
+}
+
+func (c *CLI) processVerifyCommand(args []string) {
+	if len(args) != 0 {
+		fmt.Println("Usage: VERIFY")
+		return
+	}
+	report, err := c.db.Verify()
+	if err != nil {
+		fmt.Println(err)
+		return
+	}
+	fmt.Println(report)
+}
+
+// Prints the result of a background job once it is done.
+func (c *CLI) reportJob(j *db.Job) {
+	go func() {
+		stats, err := j.Wait()
+		if err != nil {
+			fmt.Printf("\n%s failed: %v\n", stats.Kind, err)
+		} else {
+			fmt.Printf("\n%s\n", stats)
+		}
+		c.printPrompt()
+	}()
 }
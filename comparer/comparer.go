@@ -0,0 +1,71 @@
+package comparer
+
+import "bytes"
+
+// Comparer defines the order of keys in memtables and sstables.
+type Comparer interface {
+	// Compare returns -1, 0 or +1 depending on whether a is less than,
+	// equal to or greater than b.
+	Compare(a, b []byte) int
+
+	// Name identifies the order. It is recorded in every sstable, so it
+	// must change whenever the order of any two keys changes.
+	Name() string
+
+	// Separator appends to dst a key k such that a <= k < b, which is used
+	// as an index key between two data blocks. Returning a is always valid,
+	// a shorter key only makes the index smaller.
+	Separator(dst, a, b []byte) []byte
+
+	// Successor appends to dst a key k >= a. Returning a is always valid.
+	Successor(dst, a []byte) []byte
+}
+
+// Bytewise orders keys lexicographically by their bytes.
+var Bytewise Comparer = bytewise{}
+
+type bytewise struct{}
+
+func (bytewise) Compare(a, b []byte) int {
+	return bytes.Compare(a, b)
+}
+
+func (bytewise) Name() string {
+	return "lsm-tree.BytewiseComparator"
+}
+
+func (bytewise) Separator(dst, a, b []byte) []byte {
+	n := sharedPrefixLen(a, b)
+	if n >= len(a) || n >= len(b) {
+		// One key is a prefix of the other.
+		return append(dst, a...)
+	}
+	if c := a[n]; c < 0xff && c+1 < b[n] {
+		dst = append(dst, a[:n+1]...)
+		dst[len(dst)-1]++
+		return dst
+	}
+	return append(dst, a...)
+}
+
+func (bytewise) Successor(dst, a []byte) []byte {
+	for i, c := range a {
+		if c != 0xff {
+			dst = append(dst, a[:i+1]...)
+			dst[len(dst)-1]++
+			return dst
+		}
+	}
+	// a is a run of 0xff bytes.
+	return append(dst, a...)
+}
+
+func sharedPrefixLen(a, b []byte) int {
+	n := min(len(a), len(b))
+	for i := 0; i < n; i++ {
+		if a[i] != b[i] {
+			return i
+		}
+	}
+	return n
+}
@@ -0,0 +1,66 @@
+package db
+
+import "github.com/wubba-com/lsm-tree/sstable"
+
+// ApproximateSize returns the approximate number of bytes taken by the keys
+// in [start, end] in sstables and memtables. A nil start or end leaves the
+// range unbounded on that side. Sstables are estimated from their index
+// blocks without reading any data block, so the result may be larger than
+// the exact one by about a data block at each end of the range per sstable.
+func (d *DB) ApproximateSize(start, end []byte) (int64, error) {
+	size, _, err := d.approximateRange(start, end)
+	return size, err
+}
+
+// ApproximateCount returns the approximate number of entries with keys in
+// [start, end], estimated like ApproximateSize. Every version of a key that
+// has not been compacted away counts, and so does every deletion. Sstables
+// written before their number of entries was recorded count as empty.
+func (d *DB) ApproximateCount(start, end []byte) (int64, error) {
+	_, count, err := d.approximateRange(start, end)
+	return count, err
+}
+
+func (d *DB) approximateRange(start, end []byte) (size, count int64, err error) {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return 0, 0, ErrClosed
+	}
+	for _, m := range d.memtables.queue {
+		s, c := m.RangeSize(start, end)
+		size += int64(s)
+		count += int64(c)
+	}
+	v := d.refVersion()
+	d.mu.Unlock()
+	defer d.releaseVersion(v)
+
+	for _, t := range allTables(&v.levels) {
+		if start != nil && d.cmp.Compare(t.largest, start) < 0 || end != nil && d.cmp.Compare(t.smallest, end) > 0 {
+			continue
+		}
+		s, c, err := d.approximateTableRange(t, start, end)
+		if err != nil {
+			return 0, 0, err
+		}
+		size += s
+		count += c
+	}
+	return size, count, nil
+}
+
+func (d *DB) approximateTableRange(t *tableMeta, start, end []byte) (size, count int64, err error) {
+	f, err := d.dataStorage.OpenFileForReading(t.FileMetadata)
+	if err != nil {
+		return 0, 0, err
+	}
+	r, err := sstable.NewReader(f, d.cmp)
+	if err != nil {
+		f.Close()
+		return 0, 0, err
+	}
+	defer r.Close()
+
+	return r.ApproximateRange(start, end)
+}
@@ -0,0 +1,68 @@
+package db
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestApproximateSizeAndCount(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	key := func(i int) []byte { return []byte(fmt.Sprintf("key%05d", i)) }
+
+	for i := 0; i < 10000; i++ {
+		d.Set(key(i), []byte(fmt.Sprintf("value%05d", i)))
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// Only in the memtable.
+	for i := 10000; i < 10010; i++ {
+		d.Set(key(i), []byte("v"))
+	}
+
+	count, err := d.ApproximateCount(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 10010 {
+		t.Fatalf("expected 10010 entries, got %d", count)
+	}
+	total, err := d.ApproximateSize(nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var tablesSize int64
+	for _, l := range d.Metrics().Levels {
+		tablesSize += l.Size
+	}
+	if total <= 0 || total > tablesSize+d.Metrics().Memtables.Size {
+		t.Fatalf("expected a size of at most %d, got %d", tablesSize, total)
+	}
+
+	// A quarter of the keys, within a few blocks.
+	count, err = d.ApproximateCount(key(2500), key(4999))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count < 2500 || count > 2500*11/10 {
+		t.Fatalf("expected about 2500 entries, got %d", count)
+	}
+	size, err := d.ApproximateSize(key(2500), key(4999))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if size < total/5 || size > total*3/10 {
+		t.Fatalf("expected about a quarter of %d bytes, got %d", total, size)
+	}
+
+	if count, _ = d.ApproximateCount(key(10005), nil); count != 5 {
+		t.Fatalf("expected the 5 memtable entries, got %d", count)
+	}
+	if count, _ = d.ApproximateCount([]byte("zzz"), nil); count != 0 {
+		t.Fatalf("expected no entries past the last key, got %d", count)
+	}
+}
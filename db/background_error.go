@@ -0,0 +1,66 @@
+package db
+
+import "errors"
+
+// ErrBackgroundError is returned by writes once a background flush or
+// compaction, or an append to the WAL has failed, e.g. because the disk is
+// full. It wraps the error of the failed job. Reads keep working; call
+// Resume to allow writes again.
+var ErrBackgroundError = errors.New("db is read-only after a background error")
+
+// Records the first error of background work, which stops further writes,
+// flushes and compactions until Resume. Must be called with d.mu held.
+func (d *DB) setBackgroundError(err error) {
+	if d.bgErr == nil {
+		d.bgErr = err
+		d.opts.Logger.Errorf("background error, writes are stopped until Resume: %v", err)
+		d.opts.EventListener.backgroundError(err)
+	}
+	// Wake up writers blocked by a write stall, which now fail.
+	d.workDone.Broadcast()
+}
+
+// Records a non-nil err of a job as the background error and returns it.
+// Must be called without d.mu held.
+func (d *DB) noteBackgroundError(err error) error {
+	if err != nil {
+		d.mu.Lock()
+		d.setBackgroundError(err)
+		d.mu.Unlock()
+	}
+	return err
+}
+
+// Resume clears the error of a failed background flush, compaction or WAL
+// append and retries the flush of pending memtables, e.g. after space has
+// been freed on the disk. If the flush fails again, the DB stays read-only
+// and the error is returned.
+func (d *DB) Resume() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	if d.bgErr == nil {
+		d.mu.Unlock()
+		return nil
+	}
+	d.bgErr = nil
+	// A failed append may have left a torn record at the end of the WAL.
+	d.wal.switchPending = true
+	d.mu.Unlock()
+
+	err := d.flushMemtables(nil)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err != nil {
+		d.setBackgroundError(err)
+		return err
+	}
+	d.opts.Logger.Infof("resumed after background error")
+	d.maybeScheduleFlush()
+	d.maybeScheduleCompaction()
+	return nil
+}
@@ -0,0 +1,66 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackgroundErrorAndResume(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "db")
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 100; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v"))
+	}
+
+	// Flushes fail while the directory is gone.
+	moved := dir + ".moved"
+	if err = os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Flush().Wait(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	if err = d.Set([]byte("key"), []byte("v")); !errors.Is(err, ErrBackgroundError) {
+		t.Fatalf("expected ErrBackgroundError, got %v", err)
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); !errors.Is(err, ErrBackgroundError) {
+		t.Fatalf("expected ErrBackgroundError, got %v", err)
+	}
+	if v, err := d.Get([]byte("key0042")); err != nil || string(v) != "v" {
+		t.Fatalf("expected reads to keep working, got %q, %v", v, err)
+	}
+	if err = d.Resume(); err == nil {
+		t.Fatal("expected Resume to fail while the directory is gone")
+	}
+
+	if err = os.Rename(moved, dir); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Resume(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Set([]byte("key"), []byte("v")); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	for _, key := range []string{"key0042", "key"} {
+		if v, err := r.Get([]byte(key)); err != nil || string(v) != "v" {
+			t.Fatalf("%s: unexpected value %q, %v", key, v, err)
+		}
+	}
+}
@@ -0,0 +1,353 @@
+package db
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+const (
+	backupSharedDir     = "shared"
+	backupMetaDir       = "meta"
+	backupCheckpointDir = "checkpoint.tmp"
+)
+
+// BackupEngine maintains incremental backups of a DB in a directory:
+//
+//	shared/  sstables shared between backups
+//	meta/    one metadata file per backup
+//
+// Sstables never change once written, so a table already present in
+// shared/ is not copied again by later backups.
+type BackupEngine struct {
+	mu  sync.Mutex
+	dir string
+}
+
+type BackupInfo struct {
+	ID        int
+	Timestamp time.Time
+	Size      int64 // total size of the backed up sstables in bytes
+	NumFiles  int
+}
+
+type backupMeta struct {
+	ID        int          `json:"id"`
+	Timestamp time.Time    `json:"timestamp"`
+	Manifest  []byte       `json:"manifest"` // manifest of the backed up DB
+	Files     []backupFile `json:"files"`
+}
+
+type backupFile struct {
+	FileNum  int    `json:"file_num"`
+	Path     string `json:"path"` // relative to the backup directory
+	Size     int64  `json:"size"`
+	Checksum uint32 `json:"crc32"`
+}
+
+func OpenBackupEngine(dir string) (*BackupEngine, error) {
+	for _, sub := range []string{backupSharedDir, backupMetaDir} {
+		err := os.MkdirAll(filepath.Join(dir, sub), 0755)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return &BackupEngine{dir: dir}, nil
+}
+
+// CreateBackup backs up the current state of d and returns its description.
+func (e *BackupEngine) CreateBackup(d *DB) (BackupInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	// A checkpoint pins a consistent set of sstables for the time of copying.
+	checkpointDir := filepath.Join(e.dir, backupCheckpointDir)
+	err := os.RemoveAll(checkpointDir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	err = d.Checkpoint(checkpointDir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	defer os.RemoveAll(checkpointDir)
+
+	checkpoint, err := storage.NewProvider(checkpointDir)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	buf, err := checkpoint.ReadManifest()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	m, err := decodeManifest(buf)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+
+	metas, err := e.readAllMeta()
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	meta := &backupMeta{ID: 1, Timestamp: time.Now(), Manifest: buf}
+	if len(metas) > 0 {
+		meta.ID = metas[len(metas)-1].ID + 1
+	}
+
+	for _, t := range allTables(&m.levels) {
+		f, err := e.backupTable(checkpoint.Path(t.FileMetadata), t.FileNum())
+		if err != nil {
+			return BackupInfo{}, err
+		}
+		meta.Files = append(meta.Files, f)
+	}
+
+	err = e.writeMeta(meta)
+	if err != nil {
+		return BackupInfo{}, err
+	}
+	return meta.info(), nil
+}
+
+// Copies the sstable into shared/ unless an identical one is already there.
+func (e *BackupEngine) backupTable(src string, fileNum int) (backupFile, error) {
+	size, checksum, err := fileChecksum(src)
+	if err != nil {
+		return backupFile{}, err
+	}
+	f := backupFile{
+		FileNum:  fileNum,
+		Path:     filepath.Join(backupSharedDir, fmt.Sprintf("%06d_%08x_%d.sst", fileNum, checksum, size)),
+		Size:     size,
+		Checksum: checksum,
+	}
+
+	dst := filepath.Join(e.dir, f.Path)
+	if _, err = os.Stat(dst); err == nil {
+		return f, nil
+	}
+	tmp := dst + ".tmp"
+	_, copied, err := storage.CopyFile(src, tmp)
+	if err == nil && copied != checksum {
+		err = fmt.Errorf("sstable %06d changed while being backed up", fileNum)
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return backupFile{}, err
+	}
+	return f, os.Rename(tmp, dst)
+}
+
+// ListBackups returns all backups from oldest to newest.
+func (e *BackupEngine) ListBackups() ([]BackupInfo, error) {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	metas, err := e.readAllMeta()
+	if err != nil {
+		return nil, err
+	}
+	infos := make([]BackupInfo, 0, len(metas))
+	for _, meta := range metas {
+		infos = append(infos, meta.info())
+	}
+	return infos, nil
+}
+
+// PurgeOldBackups deletes all but the keep newest backups along with the
+// shared sstables no remaining backup refers to.
+func (e *BackupEngine) PurgeOldBackups(keep int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	metas, err := e.readAllMeta()
+	if err != nil {
+		return err
+	}
+	for len(metas) > max(keep, 0) {
+		err = os.Remove(e.metaPath(metas[0].ID))
+		if err != nil {
+			return err
+		}
+		metas = metas[1:]
+	}
+
+	referenced := make(map[string]bool)
+	for _, meta := range metas {
+		for _, f := range meta.Files {
+			referenced[f.Path] = true
+		}
+	}
+	entries, err := os.ReadDir(filepath.Join(e.dir, backupSharedDir))
+	if err != nil {
+		return err
+	}
+	for _, entry := range entries {
+		path := filepath.Join(backupSharedDir, entry.Name())
+		if referenced[path] {
+			continue
+		}
+		err = os.Remove(filepath.Join(e.dir, path))
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// VerifyBackup checks that every file of the backup is present and that its
+// size and checksum match the ones recorded when the backup was created.
+func (e *BackupEngine) VerifyBackup(id int) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	meta, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+	for _, f := range meta.Files {
+		size, checksum, err := fileChecksum(filepath.Join(e.dir, f.Path))
+		if err != nil {
+			return err
+		}
+		if size != f.Size || checksum != f.Checksum {
+			return fmt.Errorf("backup %d: %s is corrupted", id, f.Path)
+		}
+	}
+	return nil
+}
+
+// RestoreBackup recreates the DB saved by the backup in dir, which must not
+// exist yet. The result can be opened with Open.
+func (e *BackupEngine) RestoreBackup(id int, dir string) error {
+	e.mu.Lock()
+	defer e.mu.Unlock()
+
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("restore directory %q already exists", dir)
+	}
+	meta, err := e.readMeta(id)
+	if err != nil {
+		return err
+	}
+
+	// Restore aside and move the DB into place once it is complete.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	err = e.restore(meta, tmpDir)
+	if err == nil {
+		err = os.Rename(tmpDir, dir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+func (e *BackupEngine) restore(meta *backupMeta, dir string) error {
+	dst, err := storage.NewProvider(dir)
+	if err != nil {
+		return err
+	}
+	for _, f := range meta.Files {
+		path := dst.Path(storage.NewFileMetadata(f.FileNum, storage.FileTypeSSTable))
+		_, checksum, err := storage.CopyFile(filepath.Join(e.dir, f.Path), path)
+		if err != nil {
+			return err
+		}
+		if checksum != f.Checksum {
+			return fmt.Errorf("backup %d: %s is corrupted", meta.ID, f.Path)
+		}
+	}
+	return dst.WriteManifest(meta.Manifest)
+}
+
+func (e *BackupEngine) metaPath(id int) string {
+	return filepath.Join(e.dir, backupMetaDir, strconv.Itoa(id))
+}
+
+func (e *BackupEngine) readMeta(id int) (*backupMeta, error) {
+	buf, err := os.ReadFile(e.metaPath(id))
+	if err != nil {
+		return nil, err
+	}
+	meta := &backupMeta{}
+	err = json.Unmarshal(buf, meta)
+	if err != nil {
+		return nil, fmt.Errorf("backup %d: %w", id, err)
+	}
+	return meta, nil
+}
+
+// Reads the metadata of all backups ordered by id.
+func (e *BackupEngine) readAllMeta() ([]*backupMeta, error) {
+	entries, err := os.ReadDir(filepath.Join(e.dir, backupMetaDir))
+	if err != nil {
+		return nil, err
+	}
+	var metas []*backupMeta
+	for _, entry := range entries {
+		id, err := strconv.Atoi(entry.Name())
+		if err != nil {
+			continue // Not a backup, e.g. an interrupted write.
+		}
+		meta, err := e.readMeta(id)
+		if err != nil {
+			return nil, err
+		}
+		metas = append(metas, meta)
+	}
+	sort.Slice(metas, func(i, j int) bool {
+		return metas[i].ID < metas[j].ID
+	})
+	return metas, nil
+}
+
+func (e *BackupEngine) writeMeta(meta *backupMeta) error {
+	buf, err := json.MarshalIndent(meta, "", "  ")
+	if err != nil {
+		return err
+	}
+	path := e.metaPath(meta.ID)
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, buf, 0644)
+	if err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+func (meta *backupMeta) info() BackupInfo {
+	info := BackupInfo{ID: meta.ID, Timestamp: meta.Timestamp, NumFiles: len(meta.Files)}
+	for _, f := range meta.Files {
+		info.Size += f.Size
+	}
+	return info
+}
+
+func fileChecksum(path string) (int64, uint32, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer f.Close()
+
+	h := crc32.NewIEEE()
+	size, err := io.Copy(h, f)
+	if err != nil {
+		return 0, 0, err
+	}
+	return size, h.Sum32(), nil
+}
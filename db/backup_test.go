@@ -0,0 +1,94 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBackupEngine(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	e, err := OpenBackupEngine(filepath.Join(dir, "backup"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 500; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v1"))
+	}
+	first, err := e.CreateBackup(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 500; i < 1000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v2"))
+	}
+	second, err := e.CreateBackup(d)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The second backup shares the sstables of the first one.
+	shared, err := os.ReadDir(filepath.Join(dir, "backup", backupSharedDir))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(shared) != second.NumFiles || first.NumFiles >= second.NumFiles {
+		t.Fatalf("expected %d shared files, got %d", second.NumFiles, len(shared))
+	}
+
+	backups, err := e.ListBackups()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(backups) != 2 || backups[0].ID != first.ID || backups[1].ID != second.ID {
+		t.Fatalf("unexpected backups %+v", backups)
+	}
+	if err = e.VerifyBackup(second.ID); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = e.PurgeOldBackups(1); err != nil {
+		t.Fatal(err)
+	}
+	if backups, _ = e.ListBackups(); len(backups) != 1 || backups[0].ID != second.ID {
+		t.Fatalf("unexpected backups after purge %+v", backups)
+	}
+
+	// A directory of the user next to the restored DB is left alone.
+	restoreDir := filepath.Join(dir, "restore")
+	if err = os.Mkdir(restoreDir+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.RestoreBackup(second.ID, restoreDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(restoreDir + ".tmp"); err != nil {
+		t.Fatalf("expected %s.tmp to be kept: %v", restoreDir, err)
+	}
+	r, err := Open(restoreDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	for _, k := range []string{"key0000", "key0999"} {
+		if _, err = r.Get([]byte(k)); err != nil {
+			t.Fatalf("%s: %v", k, err)
+		}
+	}
+
+	// Corrupt a shared sstable.
+	path := filepath.Join(dir, "backup", backupSharedDir, shared[0].Name())
+	if err = os.WriteFile(path, []byte("garbage"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = e.VerifyBackup(second.ID); err == nil {
+		t.Fatal("expected verification to fail")
+	}
+}
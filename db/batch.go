@@ -0,0 +1,91 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+)
+
+// Batch is a sequence of writes that are applied to the DB atomically.
+type Batch struct {
+	ops []batchOp
+}
+
+type batchOp struct {
+	kind encoder.OpKind
+	key  []byte
+	val  []byte
+}
+
+func NewBatch() *Batch {
+	return &Batch{}
+}
+
+func (b *Batch) Set(key, val []byte) {
+	b.ops = append(b.ops, batchOp{kind: encoder.OpKindSet, key: key, val: val})
+}
+
+func (b *Batch) Delete(key []byte) {
+	b.ops = append(b.ops, batchOp{kind: encoder.OpKindDelete, key: key})
+}
+
+func (b *Batch) Len() int {
+	return len(b.ops)
+}
+
+// Returns an upper bound of the length of the encoding of the batch.
+func (b *Batch) encodedSize() int {
+	n := binary.MaxVarintLen64
+	for _, op := range b.ops {
+		n += 1 + 2*binary.MaxVarintLen64 + len(op.key) + len(op.val)
+	}
+	return n
+}
+
+func (b *Batch) Reset() {
+	b.ops = b.ops[:0]
+}
+
+var errCorruptedBatch = errors.New("corrupted batch")
+
+// Appends the encoding of the batch to buf: [count]{[kind][len][key][len][val]}
+// with every number but the kind byte as a uvarint.
+func (b *Batch) encode(buf []byte) []byte {
+	buf = binary.AppendUvarint(buf, uint64(len(b.ops)))
+	for _, op := range b.ops {
+		buf = append(buf, byte(op.kind))
+		buf = binary.AppendUvarint(buf, uint64(len(op.key)))
+		buf = append(buf, op.key...)
+		buf = binary.AppendUvarint(buf, uint64(len(op.val)))
+		buf = append(buf, op.val...)
+	}
+	return buf
+}
+
+func decodeBatch(buf []byte) (*Batch, error) {
+	// Fields are encoded like those of the manifest.
+	d := manifestDecoder{buf: buf}
+	n := d.uvarint()
+	b := NewBatch()
+	for i := uint64(0); i < n && d.err == nil; i++ {
+		if len(d.buf) == 0 {
+			return nil, errCorruptedBatch
+		}
+		kind := encoder.OpKind(d.buf[0])
+		d.buf = d.buf[1:]
+		key, val := d.bytes(), d.bytes()
+		switch kind {
+		case encoder.OpKindDelete:
+			b.Delete(key)
+		case encoder.OpKindSet:
+			b.Set(key, val)
+		default:
+			return nil, errCorruptedBatch
+		}
+	}
+	if d.err != nil || len(d.buf) != 0 {
+		return nil, errCorruptedBatch
+	}
+	return b, nil
+}
@@ -0,0 +1,79 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+// Checkpoint creates a consistent copy of the DB in dir, which must not
+// exist yet, while the DB stays open for writes. Memtables are flushed first
+// so that every write made before the call is included. Sstables are
+// immutable, so they are hard-linked into dir where possible and copied
+// otherwise. The result can be opened with Open. A read-only DB can only be
+// checkpointed while its memtables are empty, i.e. when it has no WAL
+// writes to replay; otherwise ErrReadOnly is returned.
+func (d *DB) Checkpoint(dir string) error {
+	if _, err := os.Stat(dir); err == nil {
+		return fmt.Errorf("checkpoint directory %q already exists", dir)
+	}
+	d.mu.Lock()
+	closed := d.closed
+	var unflushed bool
+	for _, m := range d.memtables.queue {
+		unflushed = unflushed || m.Size() > 0
+	}
+	d.mu.Unlock()
+	if closed {
+		return ErrClosed
+	}
+
+	if d.opts.ReadOnly && unflushed {
+		return fmt.Errorf("%w: checkpoint needs to flush the writes replayed from the WAL", ErrReadOnly)
+	}
+	if !d.opts.ReadOnly {
+		err := d.flushAllMemtables(nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The tables of the pinned version stay on disk while they are linked or
+	// copied, with d.mu released.
+	d.mu.Lock()
+	v := d.refVersion()
+	manifest := d.currentManifest().encode()
+	d.mu.Unlock()
+	defer d.releaseVersion(v)
+
+	// Build the checkpoint aside and move it into place once it is complete.
+	tmpDir, err := os.MkdirTemp(filepath.Dir(dir), filepath.Base(dir)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	err = d.writeCheckpoint(tmpDir, v, manifest)
+	if err == nil {
+		err = os.Rename(tmpDir, dir)
+	}
+	if err != nil {
+		os.RemoveAll(tmpDir)
+		return err
+	}
+	return nil
+}
+
+func (d *DB) writeCheckpoint(dir string, v *version, manifest []byte) error {
+	dst, err := storage.NewProvider(dir)
+	if err != nil {
+		return err
+	}
+	for _, t := range allTables(&v.levels) {
+		err = dst.LinkOrCopyFile(d.dataStorage.Path(t.FileMetadata), t.FileMetadata)
+		if err != nil {
+			return err
+		}
+	}
+	return dst.WriteManifest(manifest)
+}
@@ -0,0 +1,113 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestCheckpoint(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 1000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("val%04d", i)))
+	}
+
+	// A directory of the user next to the checkpoint is left alone.
+	checkpointDir := filepath.Join(dir, "checkpoint")
+	if err = os.Mkdir(checkpointDir+".tmp", 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Checkpoint(checkpointDir); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = os.Stat(checkpointDir + ".tmp"); err != nil {
+		t.Fatalf("expected %s.tmp to be kept: %v", checkpointDir, err)
+	}
+	if names := listDir(t, dir); len(names) != 3 {
+		t.Fatalf("expected no temporary directory to be left, got %v", names)
+	}
+	if err = d.Checkpoint(checkpointDir); err == nil {
+		t.Fatal("expected an error for an existing checkpoint directory")
+	}
+	d.Set([]byte("key0000"), []byte("changed"))
+	d.Set([]byte("new"), []byte("new"))
+
+	c, err := Open(checkpointDir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { c.Close() })
+	for i := 0; i < 1000; i++ {
+		v, err := c.Get([]byte(fmt.Sprintf("key%04d", i)))
+		if err != nil || string(v) != fmt.Sprintf("val%04d", i) {
+			t.Fatalf("unexpected value %q, %v", v, err)
+		}
+	}
+	if _, err = c.Get([]byte("new")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("write made after the checkpoint is visible: %v", err)
+	}
+
+	// The checkpoint is a regular DB that accepts writes of its own.
+	for i := 0; i < 1000; i++ {
+		c.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("overwritten"))
+	}
+	v, err := d.Get([]byte("key0999"))
+	if err != nil || string(v) != "val0999" {
+		t.Fatalf("writes to the checkpoint leaked into the DB: %q, %v", v, err)
+	}
+}
+
+func TestCheckpointReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("flushed"), []byte("v"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := Open(filepath.Join(dir, "db"), &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err = r.Checkpoint(filepath.Join(dir, "checkpoint")); err != nil {
+		t.Fatal(err)
+	}
+	r.Close()
+	c, err := Open(filepath.Join(dir, "checkpoint"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.Close()
+	if v, err := c.Get([]byte("flushed")); err != nil || string(v) != "v" {
+		t.Fatalf("expected %q, got %q, %v", "v", v, err)
+	}
+
+	// Writes only in the WAL cannot be flushed by a read-only DB.
+	d, err = Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("unflushed"), []byte("v"))
+	crash(d)
+	r, err = Open(filepath.Join(dir, "db"), &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	if err = r.Checkpoint(filepath.Join(dir, "checkpoint2")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("expected ErrReadOnly, got %v", err)
+	}
+}
@@ -0,0 +1,92 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestCloseFlushesMemtables(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v"))
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err = d.Get([]byte("key0001")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Get: expected ErrClosed, got %v", err)
+	}
+	if err = d.Set([]byte("key"), []byte("v")); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Set: expected ErrClosed, got %v", err)
+	}
+	if _, err = d.NewIterator(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("NewIterator: expected ErrClosed, got %v", err)
+	}
+	if _, err = d.Flush().Wait(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Flush: expected ErrClosed, got %v", err)
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("CompactRange: expected ErrClosed, got %v", err)
+	}
+	if err = d.Close(); !errors.Is(err, ErrClosed) {
+		t.Fatalf("Close: expected ErrClosed, got %v", err)
+	}
+
+	r, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	if v, err := r.Get([]byte("key0099")); err != nil || string(v) != "v" {
+		t.Fatalf("expected unflushed write to survive Close, got %q, %v", v, err)
+	}
+}
+
+func TestCloseReleasesStalledWrites(t *testing.T) {
+	release := make(chan struct{})
+	d, err := Open(t.TempDir(), &Options{
+		L0SlowdownThreshold: 1,
+		L0StopThreshold:     2,
+		L0CompactionTrigger: 2,
+		CompactionFilter:    blockingFilter(release),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	written := make(chan error, 1)
+	go func() {
+		for i := 0; ; i++ {
+			err := d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("val%05d", i)))
+			if err != nil {
+				written <- err
+				return
+			}
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.WriteStallStats().Stops == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected writes to be blocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	closed := make(chan error, 1)
+	go func() { closed <- d.Close() }()
+	if err = <-written; !errors.Is(err, ErrClosed) {
+		t.Fatalf("expected the stalled write to fail with ErrClosed, got %v", err)
+	}
+	// Close waits for the compaction it could not interrupt.
+	close(release)
+	if err = <-closed; err != nil {
+		t.Fatal(err)
+	}
+}
@@ -0,0 +1,205 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"slices"
+	"sync"
+)
+
+// Group commits append at most this many bytes of records, beyond the batch
+// of the leading writer.
+const maxGroupCommitSize = 1 << 20
+
+// WriteOptions control the durability of a write.
+type WriteOptions struct {
+	// Sync makes the write wait until the WAL has been synced to disk, so
+	// that it survives a crash of the machine. Without it, a write survives
+	// a crash of the process but may be lost when the machine fails.
+	Sync bool
+}
+
+func (o *WriteOptions) sync() bool {
+	return o != nil && o.Sync
+}
+
+// writer is a write waiting to be committed. Writers queue up in d.writers;
+// the first one leads a group commit for itself and the writers behind it,
+// which wait until the leader marks them done.
+type writer struct {
+	ctx   context.Context
+	batch *Batch // nil for SyncWAL
+	sync  bool
+	// check, if set, is called by the leader right before the batch is
+	// committed, and fails the write with its error. Writers with a check
+	// lead their groups, so that the check sees every write committed before.
+	check func() error
+
+	cond    sync.Cond // signaled once the writer is done or leads
+	inGroup bool      // joined the group of a leader, which now commits it
+	done    bool
+	err     error
+}
+
+// Commits the write of w: its batch is appended to the WAL, synced if
+// requested, and inserted into the memtables. Concurrent writes are grouped
+// into a single WAL append and sync. Must be called without d.mu held.
+func (d *DB) write(w *writer) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	w.cond.L = &d.mu
+	d.writers = append(d.writers, w)
+	// A follower gives up once its context is done, e.g. while the leader is
+	// held back by a write stall. Once in a group, its write may already be
+	// in the WAL, so it waits for the leader to finish.
+	stop := context.AfterFunc(w.ctx, func() {
+		d.mu.Lock()
+		defer d.mu.Unlock()
+		w.cond.Signal()
+	})
+	defer stop()
+	for !w.done && d.writers[0] != w {
+		if err := w.ctx.Err(); err != nil && !w.inGroup {
+			d.writers = slices.DeleteFunc(d.writers, func(q *writer) bool { return q == w })
+			return err
+		}
+		w.cond.Wait()
+	}
+	if w.done {
+		return w.err
+	}
+
+	group, err := d.prepareGroup(w)
+	if err == nil {
+		// Memtables filled up by the group start in the current WAL, which
+		// holds their first writes.
+		d.wal.busy = true
+		err = d.appendGroupToWAL(group)
+		if err == nil {
+			for _, gw := range group {
+				if gw.batch != nil {
+					d.insertBatch(gw.batch)
+				}
+			}
+		}
+		d.wal.busy = false
+		d.maybeScheduleFlush()
+	}
+
+	// Writers that gave up while d.mu was released may have left the queue,
+	// so the group is popped by identity.
+	d.writers = slices.DeleteFunc(d.writers, func(q *writer) bool { return q == w || q.inGroup })
+	for _, gw := range group {
+		if gw != w {
+			gw.done, gw.err = true, err
+			gw.cond.Signal()
+		}
+	}
+	if len(d.writers) > 0 {
+		d.writers[0].cond.Signal()
+	} else {
+		// Close waits for the queue to drain.
+		d.workDone.Broadcast()
+	}
+	return err
+}
+
+// Waits until the leader w may write, and returns it together with the
+// writers queued behind it that join its group. Followers whose context is
+// done are failed and dropped from the queue; the others stay queued until
+// the group is committed. Must be called with d.mu held.
+func (d *DB) prepareGroup(w *writer) ([]*writer, error) {
+	if err := d.checkWritable(); err != nil {
+		return nil, err
+	}
+	// Only writes need to wait for room in the memtables; a SyncWAL leads a
+	// group of other SyncWALs.
+	if w.batch != nil {
+		if err := d.makeRoomForWrite(w.ctx); err != nil {
+			return nil, err
+		}
+	}
+	if w.check != nil {
+		if err := w.check(); err != nil {
+			return nil, err
+		}
+	}
+
+	group := []*writer{w}
+	size := 0
+	for _, f := range d.writers[1:] {
+		if f.check != nil || (w.batch == nil) != (f.batch == nil) {
+			break
+		}
+		if f.batch != nil {
+			size += f.batch.encodedSize()
+			if size > maxGroupCommitSize {
+				break
+			}
+		}
+		if err := f.ctx.Err(); err != nil {
+			f.done, f.err = true, err
+			f.cond.Signal()
+			continue
+		}
+		f.inGroup = true
+		group = append(group, f)
+	}
+	d.writers = slices.DeleteFunc(d.writers, func(q *writer) bool { return q.done })
+	return group, nil
+}
+
+// Appends the batches of the group to the current WAL as one write, and
+// syncs it if any writer asks for it. d.mu is released meanwhile, and
+// d.wal.busy must be set to keep the WAL from being switched; writers
+// arriving in the meantime queue up behind the group. A failed append stops
+// further writes until Resume. Must be called with d.mu held.
+func (d *DB) appendGroupToWAL(group []*writer) error {
+	if d.wal.switchPending {
+		if err := d.switchWAL(); err != nil {
+			d.setBackgroundError(fmt.Errorf("switching WAL: %w", err))
+			return d.checkWritable()
+		}
+	}
+	var buf []byte
+	var sync bool
+	for _, w := range group {
+		if w.batch != nil && w.batch.Len() > 0 {
+			buf = appendWALRecord(buf, w.batch)
+		}
+		sync = sync || w.sync
+	}
+	if len(buf) == 0 && !sync {
+		return nil
+	}
+
+	f := d.wal.f
+	d.mu.Unlock()
+	var err error
+	if len(buf) > 0 {
+		_, err = f.Write(buf)
+		d.metrics.walBytes.Add(int64(len(buf)))
+		d.metrics.walWrites.Add(1)
+	}
+	if err == nil && sync {
+		err = f.Sync()
+		d.metrics.walSyncs.Add(1)
+	}
+	d.mu.Lock()
+
+	if err != nil {
+		// The WAL may end with a torn record; later writes go to a new one.
+		d.wal.switchPending = true
+		d.setBackgroundError(fmt.Errorf("writing WAL: %w", err))
+		return d.checkWritable()
+	}
+	return nil
+}
+
+// SyncWAL syncs the writes made so far to disk, like a write with
+// WriteOptions.Sync does. It lets callers make several writes without Sync
+// and then make them all durable at once.
+func (d *DB) SyncWAL() error {
+	return d.write(&writer{ctx: context.Background(), sync: true})
+}
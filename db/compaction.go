@@ -0,0 +1,342 @@
+package db
+
+import (
+	"slices"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// CompactRange compacts every sstable holding keys in [start, end] into the
+// bottom level in the background. A nil start or end leaves the range
+// unbounded on that side. Memtables are flushed first, so everything written
+// before the call gets compacted. Older versions of keys and deleted keys are
+// dropped on the way.
+func (d *DB) CompactRange(start, end []byte) *Job {
+	j := newJob(JobCompaction)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.checkWritable(); err != nil {
+		j.fail(err)
+		return j
+	}
+	d.runJob(j, func() error {
+		return d.noteBackgroundError(d.compactRange(j, start, end))
+	})
+	return j
+}
+
+func (d *DB) compactRange(j *Job, start, end []byte) error {
+	d.compactionMu.Lock()
+	defer d.compactionMu.Unlock()
+
+	err := d.flushAllMemtables(nil)
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	inputs := pickCompactionInputs(d.cmp, &d.levels, start, end)
+	d.mu.Unlock()
+
+	return d.compact(j, inputs)
+}
+
+// Starts a background compaction once level 0 holds
+// Options.L0CompactionTrigger tables, unless one is already running.
+func (d *DB) maybeScheduleCompaction() {
+	if d.bg.compacting || d.closed || d.bgErr != nil || len(d.levels[0]) < d.opts.L0CompactionTrigger {
+		return
+	}
+
+	d.bg.compacting = true
+	d.bgWG.Add(1)
+	go func() {
+		defer d.bgWG.Done()
+		err := d.compactLevel0()
+
+		d.mu.Lock()
+		defer d.mu.Unlock()
+
+		d.bg.compacting = false
+		if err != nil {
+			d.setBackgroundError(err)
+			return
+		}
+		// Flushes may have added tables while compacting.
+		d.maybeScheduleCompaction()
+		d.workDone.Broadcast()
+	}()
+}
+
+// Compacts every level 0 table, along with the tables of other levels
+// overlapping them, into the bottom level.
+func (d *DB) compactLevel0() error {
+	d.compactionMu.Lock()
+	defer d.compactionMu.Unlock()
+
+	d.mu.Lock()
+	var smallest, largest []byte
+	for _, t := range d.levels[0] {
+		if smallest == nil || d.cmp.Compare(t.smallest, smallest) < 0 {
+			smallest = t.smallest
+		}
+		if largest == nil || d.cmp.Compare(t.largest, largest) > 0 {
+			largest = t.largest
+		}
+	}
+	var inputs []*tableMeta
+	if len(d.levels[0]) > 0 {
+		inputs = pickCompactionInputs(d.cmp, &d.levels, smallest, largest)
+	}
+	d.mu.Unlock()
+
+	return d.compact(nil, inputs)
+}
+
+// Compacts the inputs into the bottom level. Must be called with
+// d.compactionMu held, so that the inputs stay where they are.
+func (d *DB) compact(j *Job, inputs []*tableMeta) (err error) {
+	if len(inputs) == 0 {
+		return nil
+	}
+
+	d.mu.Lock()
+	defer d.releaseOutputs(d.protectOutputs())
+	var info CompactionInfo
+	for level, tables := range d.levels {
+		for _, t := range tables {
+			if slices.Contains(inputs, t) {
+				info.Inputs = append(info.Inputs, newTableInfo(t, level))
+			}
+		}
+	}
+	d.mu.Unlock()
+	d.opts.EventListener.compactionBegin(info)
+	start := time.Now()
+	defer func() {
+		info.Duration, info.Err = time.Since(start), err
+		if err != nil {
+			d.opts.Logger.Errorf("compaction of %d tables failed: %v", len(info.Inputs), err)
+		} else {
+			d.opts.Logger.Infof("compacted %d tables into %d tables in %s", len(info.Inputs), len(info.Outputs), info.Duration)
+		}
+		d.opts.EventListener.compactionEnd(info)
+	}()
+
+	outputs, err := d.compactTables(j, inputs)
+	if err != nil {
+		return err
+	}
+	err = d.installCompaction(inputs, outputs)
+	if err != nil {
+		return err
+	}
+	info.Outputs = newTableInfos(outputs, numLevels-1)
+	for _, t := range outputs {
+		d.metrics.bytesCompacted.Add(t.size)
+	}
+	return nil
+}
+
+// Returns the tables overlapping [start, end] from newest to oldest. Data of
+// the inputs ends up in the bottom level, so every table that overlaps the
+// key range of the inputs has to be compacted too: otherwise its older
+// versions of keys would end up above the newer ones.
+func pickCompactionInputs(cmp comparer.Comparer, levels *[numLevels][]*tableMeta, start, end []byte) []*tableMeta {
+	tables := allTables(levels)
+	picked := make([]bool, len(tables))
+	var smallest, largest []byte
+	pick := func(i int) {
+		t := tables[i]
+		picked[i] = true
+		if smallest == nil || cmp.Compare(t.smallest, smallest) < 0 {
+			smallest = t.smallest
+		}
+		if largest == nil || cmp.Compare(t.largest, largest) > 0 {
+			largest = t.largest
+		}
+	}
+	for i, t := range tables {
+		if (start == nil || cmp.Compare(start, t.largest) <= 0) && (end == nil || cmp.Compare(t.smallest, end) <= 0) {
+			pick(i)
+		}
+	}
+	for expanded := smallest != nil; expanded; {
+		expanded = false
+		for i, t := range tables {
+			if !picked[i] && t.overlaps(cmp, smallest, largest) {
+				pick(i)
+				expanded = true
+			}
+		}
+	}
+
+	var inputs []*tableMeta
+	for i, t := range tables {
+		if picked[i] {
+			inputs = append(inputs, t)
+		}
+	}
+	return inputs
+}
+
+// Merges the inputs, ordered from newest to oldest, into new sstables for
+// the bottom level, each about Options.TargetFileSize bytes.
+func (d *DB) compactTables(j *Job, inputs []*tableMeta) (outputs []*tableMeta, err error) {
+	var readers []*sstable.Reader
+	var tableIters []*sstable.Iterator
+	defer func() {
+		for _, r := range readers {
+			r.Close()
+		}
+		if err != nil {
+			for _, t := range outputs {
+				d.deleteTable(t.FileMetadata)
+			}
+			outputs = nil
+		}
+	}()
+
+	iters := make([]kvIterator, 0, len(inputs))
+	// Outputs are as fresh as the freshest input.
+	var flushNum int
+	for _, t := range inputs {
+		f, err := d.dataStorage.OpenFileForReading(t.FileMetadata)
+		if err != nil {
+			return outputs, err
+		}
+		r, err := sstable.NewReader(f, d.cmp)
+		if err != nil {
+			f.Close()
+			return outputs, err
+		}
+		readers = append(readers, r)
+		if n, ok := r.FlushNum(); ok {
+			flushNum = max(flushNum, n)
+		}
+
+		ti, err := r.Iterator()
+		if err != nil {
+			return outputs, err
+		}
+		tableIters = append(tableIters, ti)
+		iters = append(iters, ti)
+		j.tableRead(t.size)
+	}
+
+	var w *sstable.Writer
+	var cur *tableMeta
+	finishOutput := func() error {
+		err := w.Close()
+		w = nil
+		if err != nil {
+			return err
+		}
+		t, err := d.loadTableMeta(cur.FileMetadata)
+		if err != nil {
+			return err
+		}
+		*cur = *t
+		j.tableWritten(t.size)
+		d.opts.EventListener.tableCreated(newTableInfo(t, numLevels-1))
+		return nil
+	}
+
+	it := newMergingIterator(d.cmp, iters...)
+	for it.HasNext() {
+		key, val := it.Next()
+		// Nothing is left for a tombstone to shadow below the bottom level.
+		if encoder.OpKind(val[0]) == encoder.OpKindDelete {
+			continue
+		}
+		val, ok := d.filterEntry(numLevels-1, key, val, true)
+		if !ok {
+			continue
+		}
+
+		if w == nil {
+			d.mu.Lock()
+			meta := d.dataStorage.PrepareNewFile()
+			// Compacted writes all come from flushed WALs.
+			logNum := d.logNum
+			d.mu.Unlock()
+			f, err := d.openTableForWriting(meta, IOPriorityLow)
+			if err != nil {
+				return outputs, err
+			}
+			w = sstable.NewWriter(f, d.cmp)
+			w.SetLogNum(logNum)
+			w.SetFlushNum(flushNum)
+			cur = &tableMeta{FileMetadata: meta}
+			outputs = append(outputs, cur)
+		}
+		err = w.Add(key, val)
+		if err != nil {
+			w.Close()
+			return outputs, err
+		}
+		if w.EstimatedSize() >= d.opts.TargetFileSize {
+			err = finishOutput()
+			if err != nil {
+				return outputs, err
+			}
+		}
+	}
+	for _, ti := range tableIters {
+		if err = ti.Err(); err != nil {
+			if w != nil {
+				w.Close()
+			}
+			return outputs, err
+		}
+	}
+	if w != nil {
+		err = finishOutput()
+	}
+	return outputs, err
+}
+
+// Replaces the inputs of a compaction with its outputs in the bottom level.
+// Input tables are deleted once no read uses them anymore.
+func (d *DB) installCompaction(inputs, outputs []*tableMeta) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	obsolete := make(map[*tableMeta]bool, len(inputs))
+	for _, t := range inputs {
+		obsolete[t] = true
+	}
+	var levels [numLevels][]*tableMeta
+	for level, tables := range d.levels {
+		for _, t := range tables {
+			if !obsolete[t] {
+				levels[level] = append(levels[level], t)
+			}
+		}
+	}
+	bottom := numLevels - 1
+	for _, t := range outputs {
+		levels[bottom] = insertIntoLevel(d.cmp, levels[bottom], t)
+	}
+
+	prev := d.levels
+	d.levels = levels
+	err := d.writeManifest()
+	if err != nil {
+		d.levels = prev
+		for _, t := range outputs {
+			d.deleteTable(t.FileMetadata)
+		}
+		return err
+	}
+	d.installVersion()
+	d.tuneRateLimiter()
+	d.updateWriteStall()
+	d.workDone.Broadcast()
+
+	return nil
+}
@@ -0,0 +1,93 @@
+package db
+
+import (
+	"sync/atomic"
+
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// FilterDecision tells what to do with an entry passed to a CompactionFilter.
+type FilterDecision int
+
+const (
+	FilterKeep        FilterDecision = iota // write the entry as is
+	FilterRemove                            // drop the entry
+	FilterChangeValue                       // write the entry with a new value
+)
+
+// CompactionFilter lets applications drop or rewrite entries while flushes
+// and compactions write them to sstables, without issuing deletes.
+//
+// Filter is called for every live value with the level of the output table
+// and returns its decision, along with the new value for FilterChangeValue.
+// Deleted keys are not passed to the filter. The filter may be called
+// concurrently with reads and writes and must not modify key or val.
+type CompactionFilter interface {
+	Filter(level int, key, val []byte) (FilterDecision, []byte)
+}
+
+// CompactionFilterStats counts the decisions of the compaction filter since
+// the DB was opened.
+type CompactionFilterStats struct {
+	Kept    uint64
+	Removed uint64
+	Changed uint64
+}
+
+type compactionFilterStats struct {
+	kept, removed, changed atomic.Uint64
+}
+
+func (d *DB) CompactionFilterStats() CompactionFilterStats {
+	return CompactionFilterStats{
+		Kept:    d.filterStats.kept.Load(),
+		Removed: d.filterStats.removed.Load(),
+		Changed: d.filterStats.changed.Load(),
+	}
+}
+
+// Writes the entries of it to w, passing them through the compaction filter.
+// Returns the number of entries written.
+func (d *DB) writeFiltered(w *sstable.Writer, it kvIterator, level int, bottommost bool) (int, error) {
+	var n int
+	for it.HasNext() {
+		key, val := it.Next()
+		val, ok := d.filterEntry(level, key, val, bottommost)
+		if !ok {
+			continue
+		}
+		err := w.Add(key, val)
+		if err != nil {
+			return n, err
+		}
+		n++
+	}
+	return n, nil
+}
+
+// Passes an entry with an encoded value through the compaction filter and
+// returns the value to write, if any. A removed entry may still shadow older
+// versions of the key in higher levels, so it is replaced with a tombstone
+// unless the table is written to the bottommost level for the key.
+func (d *DB) filterEntry(level int, key, val []byte, bottommost bool) ([]byte, bool) {
+	filter := d.opts.CompactionFilter
+	if filter == nil || encoder.OpKind(val[0]) != encoder.OpKindSet {
+		return val, true
+	}
+	var e *encoder.Encoder
+	decision, newVal := filter.Filter(level, key, val[1:])
+	switch decision {
+	case FilterRemove:
+		d.filterStats.removed.Add(1)
+		if bottommost {
+			return nil, false
+		}
+		return e.Encode(encoder.OpKindDelete, nil), true
+	case FilterChangeValue:
+		d.filterStats.changed.Add(1)
+		return e.Encode(encoder.OpKindSet, newVal), true
+	}
+	d.filterStats.kept.Add(1)
+	return val, true
+}
@@ -0,0 +1,55 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"testing"
+)
+
+// Erases values "erase" and migrates values with the "v1:" prefix to "v2:".
+type testFilter struct{}
+
+func (testFilter) Filter(level int, key, val []byte) (FilterDecision, []byte) {
+	switch {
+	case string(val) == "erase":
+		return FilterRemove, nil
+	case bytes.HasPrefix(val, []byte("v1:")):
+		return FilterChangeValue, append([]byte("v2:"), val[3:]...)
+	}
+	return FilterKeep, nil
+}
+
+func TestCompactionFilter(t *testing.T) {
+	d, err := Open(t.TempDir(), &Options{CompactionFilter: testFilter{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	flush := func() {
+		if _, err := d.Flush().Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	d.Set([]byte("a"), []byte("v1:a"))
+	d.Set([]byte("b"), []byte("b"))
+	d.Set([]byte("c"), []byte("erase"))
+	flush()
+	// The older value of "b" must not resurface once the new one is removed.
+	d.Set([]byte("b"), []byte("erase"))
+	flush()
+
+	if v, err := d.Get([]byte("a")); err != nil || string(v) != "v2:a" {
+		t.Fatalf("expected changed value, got %q, %v", v, err)
+	}
+	for _, key := range []string{"b", "c"} {
+		if _, err := d.Get([]byte(key)); !errors.Is(err, ErrNotFound) {
+			t.Fatalf("expected %q to be removed, got %v", key, err)
+		}
+	}
+
+	stats := d.CompactionFilterStats()
+	if stats != (CompactionFilterStats{Kept: 1, Removed: 2, Changed: 1}) {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+}
@@ -0,0 +1,107 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// Waits until no background flush or compaction is running, e.g. before the
+// directory of the DB is removed.
+func waitForBackgroundWork(d *DB) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for d.bg.flushing || d.bg.compacting || d.bg.deleting {
+		d.workDone.Wait()
+	}
+}
+
+func TestCompactRange(t *testing.T) {
+	d, err := Open(t.TempDir(), &Options{TargetFileSize: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for round := 0; round < 3; round++ {
+		for i := 0; i < 1000; i++ {
+			d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("val%d-%d", round, i)))
+		}
+		if _, err = d.Flush().Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for i := 0; i < 1000; i += 2 {
+		d.Delete([]byte(fmt.Sprintf("key%04d", i)))
+	}
+
+	stats, err := d.CompactRange(nil, nil).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if stats.TablesRead == 0 || stats.BytesWritten == 0 || stats.BytesWritten >= stats.BytesRead {
+		t.Fatalf("unexpected stats %+v", stats)
+	}
+
+	waitForBackgroundWork(d)
+	for level := 0; level < numLevels-1; level++ {
+		if n := len(d.levels[level]); n != 0 {
+			t.Fatalf("expected level %d to be empty, got %d tables", level, n)
+		}
+	}
+	bottom := d.levels[numLevels-1]
+	if len(bottom) != stats.TablesWritten || len(bottom) < 2 {
+		t.Fatalf("expected compaction output to be split, got %d tables", len(bottom))
+	}
+	for i := 1; i < len(bottom); i++ {
+		if d.cmp.Compare(bottom[i-1].largest, bottom[i].smallest) >= 0 {
+			t.Fatalf("tables %d and %d of the bottom level overlap", i-1, i)
+		}
+	}
+
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		v, err := d.Get(key)
+		if i%2 == 0 {
+			if !errors.Is(err, ErrNotFound) {
+				t.Fatalf("expected %s to be deleted, got %q, %v", key, v, err)
+			}
+			continue
+		}
+		if want := fmt.Sprintf("val2-%d", i); err != nil || string(v) != want {
+			t.Fatalf("expected %s=%s, got %q, %v", key, want, v, err)
+		}
+	}
+}
+
+func TestCompactRangeKeepsOtherTables(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for _, prefix := range []string{"a", "b"} {
+		for i := 0; i < 100; i++ {
+			d.Set([]byte(fmt.Sprintf("%s%03d", prefix, i)), []byte("v"))
+		}
+		if _, err = d.Flush().Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	l0 := len(d.levels[0])
+
+	if _, err = d.CompactRange([]byte("b000"), []byte("b999")).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if len(d.levels[0]) == 0 || len(d.levels[0]) >= l0 {
+		t.Fatalf("expected only tables of the range to be compacted, level 0 has %d of %d tables", len(d.levels[0]), l0)
+	}
+	for _, tm := range d.levels[0] {
+		if string(tm.largest) >= "b000" {
+			t.Fatalf("table %d overlapping the range is left in level 0", tm.FileNum())
+		}
+	}
+	if v, err := d.Get([]byte("a050")); err != nil || string(v) != "v" {
+		t.Fatalf("expected a050 to survive, got %q, %v", v, err)
+	}
+}
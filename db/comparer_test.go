@@ -0,0 +1,106 @@
+package db
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// Orders keys in descending order.
+type reverseComparer struct{}
+
+func (reverseComparer) Compare(a, b []byte) int           { return bytes.Compare(b, a) }
+func (reverseComparer) Name() string                      { return "test.ReverseComparator" }
+func (reverseComparer) Separator(dst, a, _ []byte) []byte { return append(dst, a...) }
+func (reverseComparer) Successor(dst, a []byte) []byte    { return append(dst, a...) }
+
+func TestCustomComparer(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, &Options{Comparer: reverseComparer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 500; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprint(i)))
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("key0250"), []byte("updated"))
+
+	v, err := d.Get([]byte("key0100"))
+	if err != nil || string(v) != "100" {
+		t.Fatalf("expected flushed value, got %q, %v", v, err)
+	}
+
+	it, err := d.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var prev []byte
+	var n int
+	for it.HasNext() {
+		key, val := it.Next()
+		if prev != nil && bytes.Compare(prev, key) <= 0 {
+			t.Fatalf("keys out of order: %q after %q", key, prev)
+		}
+		if string(key) == "key0250" && string(val) != "updated" {
+			t.Fatalf("expected newest value for %q, got %q", key, val)
+		}
+		prev = key
+		n++
+	}
+	it.Close()
+	if n != 500 {
+		t.Fatalf("expected 500 keys, got %d", n)
+	}
+
+	// Tables written with another comparer are rejected as well.
+	external := filepath.Join(t.TempDir(), "external.sst")
+	writeExternalFile(t, external, 0, 10, "v")
+	if err = d.IngestExternalFiles([]string{external}); !errors.Is(err, sstable.ErrComparerMismatch) {
+		t.Fatalf("expected comparer mismatch on ingest, got %v", err)
+	}
+
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(dir, nil); !errors.Is(err, sstable.ErrComparerMismatch) {
+		t.Fatalf("expected comparer mismatch, got %v", err)
+	}
+}
+
+// Treats keys that differ only in case as equal.
+type caseInsensitiveComparer struct{}
+
+func (caseInsensitiveComparer) Compare(a, b []byte) int {
+	return bytes.Compare(bytes.ToLower(a), bytes.ToLower(b))
+}
+func (caseInsensitiveComparer) Name() string                      { return "test.CaseInsensitiveComparator" }
+func (caseInsensitiveComparer) Separator(dst, a, _ []byte) []byte { return append(dst, a...) }
+func (caseInsensitiveComparer) Successor(dst, a []byte) []byte    { return append(dst, a...) }
+
+func TestTxnConflictWithComparer(t *testing.T) {
+	d, err := Open(t.TempDir(), &Options{Comparer: caseInsensitiveComparer{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("counter"), []byte("1"))
+
+	txn := d.BeginTxn()
+	if _, err = txn.Get([]byte("counter")); err != nil {
+		t.Fatal(err)
+	}
+	// The comparer considers this the same key as the one read.
+	d.Set([]byte("COUNTER"), []byte("2"))
+	txn.Set([]byte("counter"), []byte("3"))
+	if err = txn.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+}
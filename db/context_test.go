@@ -0,0 +1,104 @@
+package db
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestGetContext(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("a"), []byte("v"))
+
+	if v, err := d.GetContext(context.Background(), []byte("a")); err != nil || string(v) != "v" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if _, err = d.GetContext(ctx, []byte("a")); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
+
+func TestApplyContextDuringWriteStall(t *testing.T) {
+	release := make(chan struct{})
+	d, err := Open(t.TempDir(), &Options{
+		L0SlowdownThreshold: 1,
+		L0StopThreshold:     2,
+		L0CompactionTrigger: 2,
+		CompactionFilter:    blockingFilter(release),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	// Closing release lets the held back compaction finish before Close.
+	t.Cleanup(func() { close(release) })
+
+	// Writes go on in the background until Close.
+	go func() {
+		for i := 0; d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("val%05d", i))) == nil; i++ {
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.WriteStallStats().Stops == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected writes to be blocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	b := NewBatch()
+	b.Set([]byte("key"), []byte("v"))
+	start := time.Now()
+	if err = d.ApplyContext(ctx, b); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected context.DeadlineExceeded, got %v", err)
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("ApplyContext returned %s after the deadline", elapsed)
+	}
+}
+
+func TestNewIteratorContext(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 100; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v"))
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	it, err := d.NewIteratorContext(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	var n int
+	for it.HasNext() {
+		it.Next()
+		if n++; n == 10 {
+			cancel()
+		}
+	}
+	// The iterator is already positioned on the key after the tenth one.
+	if n != 11 || !errors.Is(it.Err(), context.Canceled) {
+		t.Fatalf("expected iteration to stop after 11 keys with context.Canceled, got %d keys, %v", n, it.Err())
+	}
+	if _, err = d.NewIteratorContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Fatalf("expected context.Canceled, got %v", err)
+	}
+}
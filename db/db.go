@@ -1,9 +1,17 @@
 package db
 
 import (
+	"context"
 	"errors"
-	"log"
-
+	"fmt"
+	"io"
+	"os"
+	"slices"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/comparer"
 	"github.com/wubba-com/lsm-tree/db/storage"
 	"github.com/wubba-com/lsm-tree/memtable"
 	"github.com/wubba-com/lsm-tree/memtable/encoder"
@@ -19,32 +27,148 @@ const (
 	Folder = "demo"
 )
 
+var (
+	ErrNotFound = errors.New("key not found")
+	// ErrLocked is returned by Open when the directory is used by another DB.
+	ErrLocked = storage.ErrLocked
+	// ErrReadOnly is returned by writes to a DB opened with Options.ReadOnly.
+	ErrReadOnly = storage.ErrReadOnly
+	// ErrClosed is returned by calls made after DB.Close.
+	ErrClosed = errors.New("db is closed")
+)
+
 type DB struct {
+	mu          sync.Mutex
+	opts        *Options
+	cmp         comparer.Comparer
 	dataStorage *storage.Provider
-	sstables    []*storage.FileMetadata
-	memtables   struct {
+	dirLock     io.Closer // exclusive lock on the data directory
+	levels      [numLevels][]*tableMeta
+	seqNum      uint64 // sequence number of the latest applied write
+	flushedSeq  uint64 // largest sequence number that has been flushed to sstables
+	logNum      int    // WALs with smaller numbers hold only flushed writes
+	wal         wal
+	writers     []*writer // writes waiting to be committed; the first one leads
+	current     *version  // the version made of levels, once installed
+	locks       *lockManager
+	nextTxnID   atomic.Uint64
+	filterStats compactionFilterStats
+	// Serializes compactions and ingestion, which both move tables between
+	// levels. Acquired before mu.
+	compactionMu sync.Mutex
+	flushMu      sync.Mutex // serializes flushes; acquired before mu
+	workDone     sync.Cond  // broadcast on d.mu whenever background work makes progress
+	bgWG         sync.WaitGroup
+	bg           struct {
+		flushing   bool // a background flush is running
+		compacting bool // a background compaction is running
+		deleting   bool // obsolete files are being deleted in the background
+	}
+	obsolete struct {
+		queue   []*storage.FileMetadata // files waiting to be deleted
+		pending map[int]bool            // numbers of the files queued or being deleted
+		writing []int                   // smallest number of the tables each running job may write
+	}
+	stall     writeStall
+	closed    bool  // set by Close
+	bgErr     error // first error of a background flush or compaction
+	metrics   metrics
+	memtables struct {
 		mutable *memtable.Memtable   // текущая изменяемая таблица памяти
 		queue   []*memtable.Memtable // все memtables, которые еще не сброшены на диск
+		logNums []int                // номер WAL, с которого начинаются записи каждой memtable из queue
 	}
 }
 
-func Open(dirname string) (*DB, error) {
-	dataStorage, err := storage.NewProvider(dirname)
+// Open opens the DB stored in dirname, creating it if needed. opts may be
+// nil to use the defaults.
+func Open(dirname string, opts *Options) (*DB, error) {
+	db := &DB{locks: newLockManager()}
+	db.workDone.L = &db.mu
+	db.opts = opts.withDefaults()
+	db.cmp = db.opts.Comparer
+
+	var err error
+	if db.opts.ReadOnly {
+		db.dataStorage, err = storage.NewReadOnlyProvider(dirname)
+	} else {
+		db.dataStorage, err = storage.NewProvider(dirname)
+	}
 	if err != nil {
 		return nil, err
 	}
-	db := &DB{dataStorage: dataStorage}
+	// Read-only DBs do not take the lock, which would create the LOCK file.
+	if !db.opts.ReadOnly {
+		db.dirLock, err = db.dataStorage.Lock()
+		if err != nil {
+			return nil, err
+		}
+	}
 	err = db.loadSSTables()
 	if err != nil {
+		db.releaseDirLock()
 		return nil, err
 	}
-	db.memtables.mutable = memtable.NewMemtable(memtableSizeLimit)
+	db.installVersion()
+	db.memtables.mutable = memtable.NewMemtable(memtableSizeLimit, db.cmp)
 	db.memtables.queue = append(db.memtables.queue, db.memtables.mutable)
+	db.memtables.logNums = append(db.memtables.logNums, db.logNum)
 
+	err = db.recoverWALs()
+	if err == nil {
+		db.mu.Lock()
+		err = db.deleteUnreferencedFiles()
+		db.mu.Unlock()
+	}
+	if err != nil {
+		db.bgWG.Wait()
+		db.closeWAL()
+		db.releaseDirLock()
+		return nil, err
+	}
 	return db, nil
 }
 
 func (d *DB) loadSSTables() error {
+	buf, err := d.dataStorage.ReadManifest()
+	if errors.Is(err, os.ErrNotExist) {
+		return d.loadSSTablesFromDir()
+	}
+	if err != nil {
+		return err
+	}
+	m, err := decodeManifest(buf)
+	if err != nil {
+		return err
+	}
+	if m.comparer != d.cmp.Name() {
+		return fmt.Errorf("%w: DB was created with %q, opened with %q", sstable.ErrComparerMismatch, m.comparer, d.cmp.Name())
+	}
+	d.levels = m.levels
+	d.logNum = m.logNum
+	d.dataStorage.MarkFileNumUsed(m.nextFileNum - 1)
+
+	// Manifests of the first version do not record key ranges.
+	var upgraded bool
+	for i, t := range d.levels[0] {
+		if t.largest != nil {
+			continue
+		}
+		d.levels[0][i], err = d.loadTableMeta(t.FileMetadata)
+		if err != nil {
+			return err
+		}
+		upgraded = true
+	}
+	if upgraded && !d.opts.ReadOnly {
+		return d.writeManifest()
+	}
+	return nil
+}
+
+// Databases created before the manifest was introduced consist of every
+// sstable in the data directory.
+func (d *DB) loadSSTablesFromDir() error {
 	meta, err := d.dataStorage.ListFiles()
 	if err != nil {
 		return err
@@ -53,80 +177,215 @@ func (d *DB) loadSSTables() error {
 		if !f.IsSSTable() {
 			continue
 		}
-		d.sstables = append(d.sstables, f)
+		t, err := d.loadTableMeta(f)
+		if err != nil {
+			return err
+		}
+		d.levels[0] = append(d.levels[0], t)
 	}
-	return nil
+	if d.opts.ReadOnly {
+		return nil
+	}
+	return d.writeManifest()
 }
 
 func (d *DB) Get(key []byte) ([]byte, error) {
+	return d.get(context.Background(), key, nil)
+}
+
+// GetContext is like Get, but gives up with ctx.Err() once ctx is done. The
+// context is checked before every sstable block is read.
+func (d *DB) GetContext(ctx context.Context, key []byte) ([]byte, error) {
+	return d.get(ctx, key, nil)
+}
+
+// Looks up key, recording the search in trace, which may be nil.
+func (d *DB) get(ctx context.Context, key []byte, trace *GetTrace) ([]byte, error) {
+	start := time.Now()
+	defer func() {
+		elapsed := time.Since(start)
+		d.metrics.get.record(elapsed)
+		if trace != nil {
+			trace.Duration = elapsed
+		}
+	}()
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	d.metrics.gets.Add(1)
 	// Scan memtables from newest to oldest.
 	for i := len(d.memtables.queue) - 1; i >= 0; i-- {
 		m := d.memtables.queue[i]
+		step := TraceStep{Kind: TraceMemtable, Memtable: len(d.memtables.queue) - 1 - i}
+		probeStart := time.Now()
 		encodedValue, err := m.Get(key)
+		step.Duration = time.Since(probeStart)
 		if err != nil {
+			trace.add(step)
 			continue // The only possible error is "key not found".
 		}
+		d.mu.Unlock()
 
 		if encodedValue.IsTombstone() {
-			log.Printf(`Found key "%s" marked as deleted in memtable "%d".`, key, i)
-
-			return nil, errors.New("key not found")
+			step.Outcome = TraceDeleted
+			trace.add(step)
+			return nil, ErrNotFound
 		}
-
-		log.Printf(`Found key "%s" in memtable "%d" with value "%s"`, key, i, encodedValue.Value())
-
+		step.Outcome = TraceFound
+		trace.add(step)
 		return encodedValue.Value(), nil
 	}
-
-	// Scan sstables from newest to oldest.
-	for j := len(d.sstables) - 1; j >= 0; j-- {
-		meta := d.sstables[j]
-		f, err := d.dataStorage.OpenFileForReading(meta)
-		if err != nil {
-			return nil, err
+	v := d.refVersion()
+	d.mu.Unlock()
+	defer d.releaseVersion(v)
+	tables := tablesForKey(d.cmp, &v.levels, key, trace != nil)
+
+	// Scan sstables that may contain the key from newest to oldest.
+	for _, t := range tables {
+		step := TraceStep{Kind: TraceTable, FileNum: t.FileNum(), Level: t.level}
+		if t.ruledOut {
+			step.Outcome = TraceRuledOut
+			trace.add(step)
+			continue
+		}
+		d.metrics.tablesProbed.Add(1)
+		probeStart := time.Now()
+		encodedValue, stats, err := d.getFromTable(ctx, t.tableMeta, key)
+		step.ReadStats, step.Duration = stats, time.Since(probeStart)
+		if errors.Is(err, sstable.ErrKeyNotFound) {
+			trace.add(step)
+			continue
 		}
-		var r *sstable.Reader
-		r, err = sstable.NewReader(f)
 		if err != nil {
+			step.Outcome = TraceReadError
+			trace.add(step)
 			return nil, err
 		}
+		if encodedValue.IsTombstone() {
+			step.Outcome = TraceDeleted
+			trace.add(step)
+			return nil, ErrNotFound
+		}
+		step.Outcome = TraceFound
+		trace.add(step)
+		return encodedValue.Value(), nil
+	}
 
-		defer r.Close()
+	return nil, ErrNotFound
+}
 
-		var encodedValue *encoder.EncodedValue
+// Looks up key in a single sstable. Fails with sstable.ErrKeyNotFound if
+// the table does not hold the key.
+func (d *DB) getFromTable(ctx context.Context, t *tableMeta, key []byte) (*encoder.EncodedValue, sstable.ReadStats, error) {
+	f, err := d.dataStorage.OpenFileForReading(t.FileMetadata)
+	if err != nil {
+		return nil, sstable.ReadStats{}, err
+	}
+	r, err := sstable.NewReader(f, d.cmp)
+	if err != nil {
+		f.Close()
+		return nil, sstable.ReadStats{}, err
+	}
+	defer r.Close()
 
-		encodedValue, err = r.Get(key)
-		if err != nil {
-			if errors.Is(err, sstable.ErrKeyNotFound) {
-				continue
-			}
-			log.Fatal(err)
-		}
-		if encodedValue.IsTombstone() {
-			log.Printf(`Found key "%s" marked as deleted in sstable "%d".`, key, meta.FileNum())
+	encodedValue, err := r.GetContext(ctx, key)
+	return encodedValue, r.Stats(), err
+}
 
-			return nil, errors.New("key not found")
-		}
-		log.Printf(`Found key "%s" in sstable "%d" with value "%s"`, key, meta.FileNum(), encodedValue.Value())
+func (d *DB) Set(key, val []byte) error {
+	return d.SetWithOptions(key, val, nil)
+}
 
-		return encodedValue.Value(), nil
-	}
+// SetWithOptions is like Set, with the durability requested by opts, which
+// may be nil.
+func (d *DB) SetWithOptions(key, val []byte, opts *WriteOptions) error {
+	start := time.Now()
+	defer func() { d.metrics.set.record(time.Since(start)) }()
+
+	b := NewBatch()
+	b.Set(key, val)
+	return d.write(&writer{ctx: context.Background(), batch: b, sync: opts.sync()})
+}
+
+func (d *DB) Delete(key []byte) error {
+	return d.DeleteWithOptions(key, nil)
+}
+
+// DeleteWithOptions is like Delete, with the durability requested by opts,
+// which may be nil.
+func (d *DB) DeleteWithOptions(key []byte, opts *WriteOptions) error {
+	start := time.Now()
+	defer func() { d.metrics.delete.record(time.Since(start)) }()
+
+	b := NewBatch()
+	b.Delete(key)
+	return d.write(&writer{ctx: context.Background(), batch: b, sync: opts.sync()})
+}
+
+// Apply atomically applies all writes of the batch: concurrent readers
+// observe either none or all of them.
+func (d *DB) Apply(b *Batch) error {
+	return d.ApplyContext(context.Background(), b)
+}
 
-	return nil, errors.New("key not found")
+// ApplyContext is like Apply, but gives up with ctx.Err() once ctx is done
+// before the batch is applied, including while a write stall holds it back.
+func (d *DB) ApplyContext(ctx context.Context, b *Batch) error {
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+	return d.write(&writer{ctx: ctx, batch: b})
 }
 
-func (d *DB) Set(key, val []byte) {
-	m := d.prepMemtableForKV(key, val)
-	m.Insert(key, val)
+// ApplyWithOptions is like Apply, with the durability requested by opts,
+// which may be nil.
+func (d *DB) ApplyWithOptions(b *Batch, opts *WriteOptions) error {
+	return d.write(&writer{ctx: context.Background(), batch: b, sync: opts.sync()})
+}
 
-	d.maybeScheduleFlush()
+// Returns the error to fail writes with, if any.
+func (d *DB) checkWritable() error {
+	if d.closed {
+		return ErrClosed
+	}
+	if d.opts.ReadOnly {
+		return ErrReadOnly
+	}
+	if d.bgErr != nil {
+		return fmt.Errorf("%w: %w", ErrBackgroundError, d.bgErr)
+	}
+	return nil
 }
 
-func (d *DB) Delete(key []byte) {
-	m := d.prepMemtableForKV(key, nil)
-	m.InsertTombstone(key)
+// Inserts the writes of the batch into the memtables.
+func (d *DB) insertBatch(b *Batch) {
+	for _, op := range b.ops {
+		d.seqNum++
+		m := d.prepMemtableForKV(op.key, op.val)
+		if op.kind == encoder.OpKindDelete {
+			m.InsertTombstone(op.key, d.seqNum)
+		} else {
+			m.Insert(op.key, op.val, d.seqNum)
+		}
+	}
+}
 
-	d.maybeScheduleFlush()
+// Reports whether key may have been written after the write with sequence
+// number seq. Only memtables remember per-key sequence numbers, so once
+// writes newer than seq have been flushed the answer is conservatively yes.
+func (d *DB) modifiedSince(key []byte, seq uint64) bool {
+	for i := len(d.memtables.queue) - 1; i >= 0; i-- {
+		if keySeq, ok := d.memtables.queue[i].Seq(key); ok {
+			return keySeq > seq
+		}
+	}
+	return d.flushedSeq > seq
 }
 
 // Гарантирует, что в изменяемой memtable достаточно места
@@ -141,16 +400,32 @@ func (d *DB) prepMemtableForKV(key, val []byte) *memtable.Memtable {
 }
 
 func (d *DB) rotateMemtables() *memtable.Memtable {
-	d.memtables.mutable = memtable.NewMemtable(memtableSizeLimit)
+	// Writes to the new memtable go to a new WAL, so that the WALs of older
+	// memtables can be deleted once those are flushed. While a group commit
+	// appends to the current WAL, the switch is left to the next group, and
+	// the new memtable starts in the current WAL.
+	d.wal.switchPending = true
+	if d.wal.f != nil && !d.wal.busy {
+		if err := d.switchWAL(); err != nil {
+			d.opts.Logger.Errorf("switching WAL: %v", err)
+		}
+	}
+	d.memtables.mutable = memtable.NewMemtable(memtableSizeLimit, d.cmp)
 	d.memtables.queue = append(d.memtables.queue, d.memtables.mutable)
+	d.memtables.logNums = append(d.memtables.logNums, d.wal.num)
 
 	return d.memtables.mutable
 }
 
+// Starts a background flush once immutable memtables hold more than
+// memtableFlushThreshold bytes, unless a flush is already running.
 func (d *DB) maybeScheduleFlush() {
+	if d.bg.flushing || d.closed || d.bgErr != nil {
+		return
+	}
 	var totalSize int
 
-	for i := 0; i < len(d.memtables.queue); i++ {
+	for i := 0; i < len(d.memtables.queue)-1; i++ {
 		totalSize += d.memtables.queue[i].Size()
 	}
 
@@ -158,36 +433,233 @@ func (d *DB) maybeScheduleFlush() {
 		return
 	}
 
-	err := d.flushMemtables()
-	if err != nil {
-		log.Fatal(err)
-	}
-}
+	d.bg.flushing = true
+	d.bgWG.Add(1)
+	go func() {
+		defer d.bgWG.Done()
+		err := d.flushMemtables(nil)
 
-func (d *DB) flushMemtables() error {
-	n := len(d.memtables.queue) - 1
-	flushable := d.memtables.queue[:n]
-	d.memtables.queue = d.memtables.queue[n:]
+		d.mu.Lock()
+		defer d.mu.Unlock()
 
-	for i := 0; i < len(flushable); i++ {
-		meta := d.dataStorage.PrepareNewFile()
-		f, err := d.dataStorage.OpenFileForWriting(meta)
+		d.bg.flushing = false
 		if err != nil {
-			return err
+			d.setBackgroundError(err)
+			return
 		}
+		// Memtables may have filled up while flushing.
+		d.maybeScheduleFlush()
+		d.workDone.Broadcast()
+	}()
+}
 
-		w := sstable.NewWriter(f)
-		err = w.Write(flushable[i])
+// Flushes every memtable that is immutable at the time of the call to a
+// level 0 sstable. Memtables stay readable until their tables are installed.
+// Progress is reported to job, which may be nil. Must be called without
+// d.mu held.
+func (d *DB) flushMemtables(job *Job) (err error) {
+	d.flushMu.Lock()
+	defer d.flushMu.Unlock()
+
+	d.mu.Lock()
+	defer d.releaseOutputs(d.protectOutputs())
+	flushable := d.memtables.queue[:len(d.memtables.queue)-1]
+	// Once memtable i is flushed, WALs below the first WAL of memtable i+1
+	// hold only flushed writes.
+	logNums := slices.Clone(d.memtables.logNums[1:len(d.memtables.queue)])
+	// With no sstables, entries removed by the compaction filter have no
+	// older versions to shadow.
+	bottommost := len(allTables(&d.levels)) == 0
+	d.mu.Unlock()
+	if len(flushable) == 0 {
+		return nil
+	}
+
+	info := FlushInfo{Memtables: len(flushable)}
+	d.opts.EventListener.flushBegin(info)
+	start := time.Now()
+	defer func() {
+		info.Duration, info.Err = time.Since(start), err
 		if err != nil {
-			return err
+			d.opts.Logger.Errorf("flush of %d memtables failed: %v", info.Memtables, err)
+		} else {
+			d.opts.Logger.Infof("flushed %d memtables to %d tables in %s", info.Memtables, len(info.Tables), info.Duration)
 		}
+		d.opts.EventListener.flushEnd(info)
+	}()
 
-		err = w.Close()
+	tables := make([]*tableMeta, len(flushable))
+	for i, m := range flushable {
+		t, err := d.writeLevel0Table(job, m, logNums[i], bottommost)
 		if err != nil {
+			for _, t := range tables[:i] {
+				if t != nil {
+					d.deleteTable(t.FileMetadata)
+				}
+			}
 			return err
 		}
+		tables[i] = t
+		bottommost = bottommost && t == nil
+	}
 
-		d.sstables = append(d.sstables, meta)
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	prevLevel0, prevLogNum := d.levels[0], d.logNum
+	for _, t := range tables {
+		if t != nil {
+			d.levels[0] = append(d.levels[0][:len(d.levels[0]):len(d.levels[0])], t)
+		}
+	}
+	d.logNum = d.memtables.logNums[len(flushable)]
+	err = d.writeManifest()
+	if err != nil {
+		// The memtables stay in the queue, to be flushed again.
+		d.levels[0], d.logNum = prevLevel0, prevLogNum
+		for _, t := range tables {
+			if t != nil {
+				d.deleteTable(t.FileMetadata)
+			}
+		}
+		return err
+	}
+	for _, t := range tables {
+		if t != nil {
+			info.Tables = append(info.Tables, newTableInfo(t, 0))
+			d.metrics.bytesFlushed.Add(t.size)
+		}
+	}
+	d.installVersion()
+	d.memtables.queue = d.memtables.queue[len(flushable):]
+	d.memtables.logNums = d.memtables.logNums[len(flushable):]
+	d.deleteObsoleteWALs()
+	for _, m := range flushable {
+		d.flushedSeq = max(d.flushedSeq, m.LastSeq())
 	}
+	d.tuneRateLimiter()
+	d.maybeScheduleCompaction()
+	d.updateWriteStall()
+	d.workDone.Broadcast()
+
 	return nil
 }
+
+// Writes the memtable to a new sstable, recording logNum as the WAL that
+// holds the oldest writes not in it or in older tables. Returns nil if the
+// compaction filter removed every entry, in which case no table is left
+// behind.
+func (d *DB) writeLevel0Table(job *Job, m *memtable.Memtable, logNum int, bottommost bool) (*tableMeta, error) {
+	d.mu.Lock()
+	meta := d.dataStorage.PrepareNewFile()
+	d.mu.Unlock()
+
+	f, err := d.openTableForWriting(meta, IOPriorityHigh)
+	if err != nil {
+		return nil, err
+	}
+	w := sstable.NewWriter(f, d.cmp)
+	w.SetLogNum(logNum)
+	w.SetFlushNum(meta.FileNum())
+	n, err := d.writeFiltered(w, m.Iterator(), 0, bottommost)
+	if err != nil {
+		w.Close()
+		d.deleteTable(meta)
+		return nil, err
+	}
+	err = w.Close()
+	if err != nil {
+		d.deleteTable(meta)
+		return nil, err
+	}
+	job.tableRead(int64(m.Size()))
+	if n == 0 {
+		d.deleteTable(meta)
+		return nil, nil
+	}
+
+	t, err := d.loadTableMeta(meta)
+	if err != nil {
+		d.deleteTable(meta)
+		return nil, err
+	}
+	job.tableWritten(t.size)
+	d.opts.EventListener.tableCreated(newTableInfo(t, 0))
+
+	return t, nil
+}
+
+// Close waits for writes in progress and background flushes and compactions
+// to finish, flushes the memtables, deletes obsolete files, closes the WAL
+// and releases the lock on the data directory. Writes blocked by a write
+// stall fail, and so does every call made after Close, with ErrClosed.
+// Iterators created before stay usable until they are closed; the tables
+// only they still use are deleted by the next Open.
+func (d *DB) Close() error {
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return ErrClosed
+	}
+	d.closed = true
+	d.workDone.Broadcast()
+	for len(d.writers) > 0 {
+		d.workDone.Wait()
+	}
+	d.mu.Unlock()
+
+	d.bgWG.Wait()
+	// Let an ingestion in progress finish.
+	d.compactionMu.Lock()
+	defer d.compactionMu.Unlock()
+
+	var err error
+	if !d.opts.ReadOnly {
+		err = d.flushAllMemtables(nil)
+	}
+	// Background goroutines are done, so delete what they left behind.
+	d.deleteObsoleteFiles()
+	return errors.Join(err, d.closeWAL(), d.releaseDirLock())
+}
+
+func (d *DB) releaseDirLock() error {
+	if d.dirLock == nil {
+		return nil
+	}
+	return d.dirLock.Close()
+}
+
+// Flushes every memtable, including the mutable one, to sstables. Must be
+// called without d.mu held.
+func (d *DB) flushAllMemtables(job *Job) error {
+	d.mu.Lock()
+	if d.memtables.mutable.Size() > 0 {
+		d.rotateMemtables()
+	}
+	d.mu.Unlock()
+
+	return d.flushMemtables(job)
+}
+
+// Flush persists every memtable, including the mutable one, to level 0
+// sstables in the background. Writes made after the call are not flushed
+// by the returned job.
+func (d *DB) Flush() *Job {
+	j := newJob(JobFlush)
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if err := d.checkWritable(); err != nil {
+		j.fail(err)
+		return j
+	}
+	if d.memtables.mutable.Size() > 0 {
+		d.rotateMemtables()
+	}
+	// Close waits for jobs started before it marks the DB closed.
+	d.runJob(j, func() error {
+		return d.noteBackgroundError(d.flushMemtables(j))
+	})
+	return j
+}
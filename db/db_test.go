@@ -9,7 +9,7 @@ import (
 
 func TestDb(t *testing.T) {
 
-	tr, err := Open(Folder)
+	tr, err := Open(Folder, nil)
 	if err != nil {
 		panic(err)
 	}
@@ -0,0 +1,191 @@
+package db
+
+import (
+	"os"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+// EventListener is notified about the background activity of the DB. Every
+// callback is optional. Callbacks may be invoked with the DB lock held, so
+// they must return quickly and must not call back into the DB.
+type EventListener struct {
+	// FlushBegin is called before memtables are written to sstables, and
+	// FlushEnd once the flush has finished or failed.
+	FlushBegin func(FlushInfo)
+	FlushEnd   func(FlushInfo)
+
+	// CompactionBegin is called before tables are merged, and
+	// CompactionEnd once the compaction has finished or failed.
+	CompactionBegin func(CompactionInfo)
+	CompactionEnd   func(CompactionInfo)
+
+	// TableCreated is called once an sstable has been written by a flush
+	// or compaction, or ingested. TableDeleted is called whenever an sstable
+	// is removed from the data directory.
+	TableCreated func(TableInfo)
+	TableDeleted func(TableDeleteInfo)
+
+	// WALCreated is called once a new write-ahead log has been created, and
+	// WALDeleted whenever one is removed from the data directory.
+	WALCreated func(WALCreateInfo)
+	WALDeleted func(WALDeleteInfo)
+
+	// WriteStallBegin is called when writes start being delayed or blocked,
+	// and WriteStallEnd once they no longer are. A change from one kind of
+	// stall to another ends the first stall and begins the second one.
+	WriteStallBegin func(WriteStallInfo)
+	WriteStallEnd   func(WriteStallInfo)
+
+	// BackgroundError is called when a background flush or compaction, or
+	// an append to the WAL fails and the DB stops accepting writes.
+	BackgroundError func(error)
+}
+
+// FlushInfo describes a flush of memtables to level 0.
+type FlushInfo struct {
+	Memtables int         // number of memtables flushed
+	Tables    []TableInfo // tables written, set by FlushEnd
+	Duration  time.Duration
+	Err       error
+}
+
+// CompactionInfo describes a compaction into the bottom level.
+type CompactionInfo struct {
+	Inputs   []TableInfo
+	Outputs  []TableInfo // set by CompactionEnd
+	Duration time.Duration
+	Err      error
+}
+
+// TableInfo describes an sstable of the DB.
+type TableInfo struct {
+	FileNum           int
+	Level             int
+	Size              int64
+	Smallest, Largest []byte
+}
+
+// TableDeleteInfo describes the removal of an sstable.
+type TableDeleteInfo struct {
+	FileNum int
+	Path    string
+	Err     error
+}
+
+// WALCreateInfo describes a new write-ahead log.
+type WALCreateInfo struct {
+	FileNum int
+	Path    string
+}
+
+// WALDeleteInfo describes the removal of a write-ahead log.
+type WALDeleteInfo struct {
+	FileNum int
+	Path    string
+	Err     error
+}
+
+func newTableInfo(t *tableMeta, level int) TableInfo {
+	return TableInfo{FileNum: t.FileNum(), Level: level, Size: t.size, Smallest: t.smallest, Largest: t.largest}
+}
+
+func newTableInfos(tables []*tableMeta, level int) []TableInfo {
+	infos := make([]TableInfo, len(tables))
+	for i, t := range tables {
+		infos[i] = newTableInfo(t, level)
+	}
+	return infos
+}
+
+// The methods below skip callbacks that are not set, and may be called on a
+// nil listener.
+
+func (l *EventListener) flushBegin(info FlushInfo) {
+	if l != nil && l.FlushBegin != nil {
+		l.FlushBegin(info)
+	}
+}
+
+func (l *EventListener) flushEnd(info FlushInfo) {
+	if l != nil && l.FlushEnd != nil {
+		l.FlushEnd(info)
+	}
+}
+
+func (l *EventListener) compactionBegin(info CompactionInfo) {
+	if l != nil && l.CompactionBegin != nil {
+		l.CompactionBegin(info)
+	}
+}
+
+func (l *EventListener) compactionEnd(info CompactionInfo) {
+	if l != nil && l.CompactionEnd != nil {
+		l.CompactionEnd(info)
+	}
+}
+
+func (l *EventListener) tableCreated(info TableInfo) {
+	if l != nil && l.TableCreated != nil {
+		l.TableCreated(info)
+	}
+}
+
+func (l *EventListener) tableDeleted(info TableDeleteInfo) {
+	if l != nil && l.TableDeleted != nil {
+		l.TableDeleted(info)
+	}
+}
+
+func (l *EventListener) walCreated(info WALCreateInfo) {
+	if l != nil && l.WALCreated != nil {
+		l.WALCreated(info)
+	}
+}
+
+func (l *EventListener) walDeleted(info WALDeleteInfo) {
+	if l != nil && l.WALDeleted != nil {
+		l.WALDeleted(info)
+	}
+}
+
+func (l *EventListener) writeStallBegin(info WriteStallInfo) {
+	if l != nil && l.WriteStallBegin != nil {
+		l.WriteStallBegin(info)
+	}
+}
+
+func (l *EventListener) writeStallEnd(info WriteStallInfo) {
+	if l != nil && l.WriteStallEnd != nil {
+		l.WriteStallEnd(info)
+	}
+}
+
+func (l *EventListener) backgroundError(err error) {
+	if l != nil && l.BackgroundError != nil {
+		l.BackgroundError(err)
+	}
+}
+
+// Removes an sstable from the data directory and reports it.
+func (d *DB) deleteTable(meta *storage.FileMetadata) {
+	path := d.dataStorage.Path(meta)
+	err := os.Remove(path)
+	if err != nil {
+		d.opts.Logger.Errorf("deleting table %d: %v", meta.FileNum(), err)
+	}
+	d.opts.EventListener.tableDeleted(TableDeleteInfo{FileNum: meta.FileNum(), Path: path, Err: err})
+}
+
+// Removes a WAL from the data directory and reports it.
+func (d *DB) deleteWAL(meta *storage.FileMetadata) {
+	path := d.dataStorage.Path(meta)
+	err := os.Remove(path)
+	if err != nil {
+		d.opts.Logger.Errorf("deleting WAL %d: %v", meta.FileNum(), err)
+	} else {
+		d.opts.Logger.Debugf("deleted WAL %d", meta.FileNum())
+	}
+	d.opts.EventListener.walDeleted(WALDeleteInfo{FileNum: meta.FileNum(), Path: path, Err: err})
+}
@@ -0,0 +1,129 @@
+package db
+
+import (
+	"bytes"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestEventListener(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	record := func(format string, args ...any) {
+		mu.Lock()
+		defer mu.Unlock()
+		events = append(events, fmt.Sprintf(format, args...))
+	}
+	listener := &EventListener{
+		FlushBegin: func(info FlushInfo) { record("flush begin %d", info.Memtables) },
+		FlushEnd: func(info FlushInfo) {
+			record("flush end %d tables, %v", len(info.Tables), info.Err)
+		},
+		CompactionBegin: func(info CompactionInfo) { record("compaction begin %d", len(info.Inputs)) },
+		CompactionEnd: func(info CompactionInfo) {
+			record("compaction end %d tables, %v", len(info.Outputs), info.Err)
+		},
+		TableCreated:    func(info TableInfo) { record("table created L%d", info.Level) },
+		TableDeleted:    func(info TableDeleteInfo) { record("table deleted %v", info.Err) },
+		BackgroundError: func(err error) { record("background error") },
+	}
+	dir := filepath.Join(t.TempDir(), "db")
+	d, err := Open(dir, &Options{EventListener: listener, CompactionFilter: testFilter{}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	// With no tables to shadow, the filter empties the flushed table, which
+	// is deleted right away.
+	d.Set([]byte("b"), []byte("erase"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("a"), []byte("a"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("a"), []byte("b"))
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	waitForBackgroundWork(d)
+
+	moved := dir + ".moved"
+	if err = os.Rename(dir, moved); err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("c"), []byte("c"))
+	if _, err = d.Flush().Wait(); err == nil {
+		t.Fatal("expected the flush to fail")
+	}
+	if err = os.Rename(moved, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	want := []string{
+		"flush begin 1",
+		"table deleted <nil>",
+		"flush end 0 tables, <nil>",
+		"flush begin 1",
+		"table created L0",
+		"flush end 1 tables, <nil>",
+		// CompactRange flushes first.
+		"flush begin 1",
+		"table created L0",
+		"flush end 1 tables, <nil>",
+		"compaction begin 2",
+		// The inputs are deleted in the background, possibly before the
+		// compaction ends.
+		"compaction end 1 tables, <nil>",
+		fmt.Sprintf("table created L%d", numLevels-1),
+		"table deleted <nil>",
+		"table deleted <nil>",
+		"flush begin 1",
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(events) != len(want)+2 {
+		t.Fatalf("unexpected events %q", events)
+	}
+	slices.Sort(events[10:14])
+	for i, e := range want {
+		if events[i] != e {
+			t.Fatalf("event %d: expected %q, got %q", i, e, events[i])
+		}
+	}
+	if !strings.HasPrefix(events[len(want)], "flush end 0 tables, ") || events[len(want)+1] != "background error" {
+		t.Fatalf("expected a failed flush and a background error, got %q", events[len(want):])
+	}
+}
+
+func TestLogger(t *testing.T) {
+	var buf bytes.Buffer
+	d, err := Open(t.TempDir(), &Options{Logger: NewStdLogger(log.New(&buf, "", 0), LogLevelInfo)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("secret-key"), []byte("secret-value"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Get([]byte("secret-key")); err != nil {
+		t.Fatal(err)
+	}
+
+	out := buf.String()
+	if !strings.Contains(out, "INFO flushed 1 memtables to 1 tables") {
+		t.Fatalf("expected the flush to be logged, got %q", out)
+	}
+	if strings.Contains(out, "secret") {
+		t.Fatalf("keys or values leaked into the log: %q", out)
+	}
+}
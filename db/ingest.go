@@ -0,0 +1,186 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"slices"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// IngestExternalFiles atomically adds sstables built with sstable.Writer to
+// the DB. The files are copied into the data directory under new file
+// numbers, so the originals are left untouched. Data of ingested files
+// takes precedence over existing data and, for overlapping files, later
+// paths take precedence over earlier ones.
+func (d *DB) IngestExternalFiles(paths []string) error {
+	external := make([]*tableMeta, len(paths))
+	for i, path := range paths {
+		t, err := validateExternalFile(d.cmp, path)
+		if err != nil {
+			return fmt.Errorf("ingest %s: %w", path, err)
+		}
+		external[i] = t
+	}
+
+	d.compactionMu.Lock()
+	defer d.compactionMu.Unlock()
+
+	// Ingested data is newer than anything in memtables, so overlapping
+	// memtables have to reach sstables first.
+	d.mu.Lock()
+	if err := d.checkWritable(); err != nil {
+		d.mu.Unlock()
+		return err
+	}
+	var overlap bool
+	for _, t := range external {
+		overlap = overlap || d.memtablesOverlap(t.smallest, t.largest)
+	}
+	d.mu.Unlock()
+	if overlap {
+		err := d.flushAllMemtables(nil)
+		if err != nil {
+			return err
+		}
+	}
+
+	// The files are copied without d.mu held, so that reads and writes go on
+	// meanwhile; they are not part of the DB until the manifest lists them.
+	d.mu.Lock()
+	defer d.releaseOutputs(d.protectOutputs())
+	d.mu.Unlock()
+	var ingested []*tableMeta
+	removeIngested := func() {
+		for _, t := range ingested {
+			d.deleteTable(t.FileMetadata)
+		}
+	}
+	for i, t := range external {
+		d.mu.Lock()
+		meta := d.dataStorage.PrepareNewFile()
+		d.mu.Unlock()
+		err := d.dataStorage.CopyFile(paths[i], meta)
+		if err != nil {
+			removeIngested()
+			return fmt.Errorf("ingest %s: %w", paths[i], err)
+		}
+		t.FileMetadata = meta
+		ingested = append(ingested, t)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.checkWritable(); err != nil {
+		removeIngested()
+		return err
+	}
+
+	levels := d.levels
+	for _, t := range ingested {
+		level := ingestLevel(d.cmp, &levels, t)
+		if level == 0 {
+			levels[0] = append(levels[0][:len(levels[0]):len(levels[0])], t)
+		} else {
+			levels[level] = insertIntoLevel(d.cmp, levels[level], t)
+		}
+	}
+
+	// The ingestion becomes visible, and durable, with the new manifest.
+	prev := d.levels
+	d.levels = levels
+	err := d.writeManifest()
+	if err != nil {
+		d.levels = prev
+		removeIngested()
+		return err
+	}
+	d.installVersion()
+
+	for level, tables := range d.levels {
+		for _, t := range tables {
+			if slices.Contains(ingested, t) {
+				d.metrics.bytesIngested.Add(t.size)
+				d.opts.EventListener.tableCreated(newTableInfo(t, level))
+			}
+		}
+	}
+
+	// Ingestion counts as a write to every key in the ingested files.
+	d.seqNum++
+	d.flushedSeq = d.seqNum
+	d.maybeScheduleCompaction()
+	d.updateWriteStall()
+
+	return nil
+}
+
+func (d *DB) memtablesOverlap(smallest, largest []byte) bool {
+	for _, m := range d.memtables.queue {
+		if m.Overlaps(smallest, largest) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the lowest level the table can be placed in: data in it must be
+// newer than data in every level it overlaps with.
+func ingestLevel(cmp comparer.Comparer, levels *[numLevels][]*tableMeta, t *tableMeta) int {
+	var target int
+	for level := 0; level < numLevels; level++ {
+		if levelOverlaps(cmp, levels[level], t.smallest, t.largest) {
+			break
+		}
+		target = level
+	}
+	return target
+}
+
+// Checks that the file is a readable non-empty sstable with keys in
+// ascending order and returns its size and key range.
+func validateExternalFile(cmp comparer.Comparer, path string) (*tableMeta, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sstable.NewReader(f, cmp)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer r.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t := &tableMeta{size: info.Size()}
+
+	it, err := r.Iterator()
+	if err != nil {
+		return nil, err
+	}
+	for it.HasNext() {
+		key, val := it.Next()
+		if len(val) == 0 || encoder.OpKind(val[0]) > encoder.OpKindSet {
+			return nil, sstable.ErrCorruptedTable
+		}
+		if t.largest != nil && cmp.Compare(t.largest, key) >= 0 {
+			return nil, fmt.Errorf("keys are not in ascending order: %w", sstable.ErrCorruptedTable)
+		}
+		if t.smallest == nil {
+			t.smallest = key
+		}
+		t.largest = key
+	}
+	if err = it.Err(); err != nil {
+		return nil, err
+	}
+	if t.largest == nil {
+		return nil, sstable.ErrEmptyTable
+	}
+	return t, nil
+}
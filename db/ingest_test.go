@@ -0,0 +1,101 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/memtable"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// Builds an sstable outside of any DB holding keys [from, to).
+func writeExternalFile(t *testing.T, path string, from, to int, val string) {
+	m := memtable.NewMemtable(1<<20, comparer.Bytewise)
+	for i := from; i < to; i++ {
+		m.Insert([]byte(fmt.Sprintf("key%04d", i)), []byte(val), 0)
+	}
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w := sstable.NewWriter(f, comparer.Bytewise)
+	if err = w.Write(m); err != nil {
+		t.Fatal(err)
+	}
+	if err = w.Close(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIngestExternalFiles(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("key0050"), []byte("memtable"))
+
+	bottom := filepath.Join(dir, "bottom.sst")
+	writeExternalFile(t, bottom, 100, 200, "bottom")
+	overlapping := filepath.Join(dir, "overlapping.sst")
+	writeExternalFile(t, overlapping, 0, 150, "overlapping")
+
+	if err = d.IngestExternalFiles([]string{bottom, overlapping}); err != nil {
+		t.Fatal(err)
+	}
+	// The first file goes to the bottom level. The second one overlaps it and
+	// the memtable, which is flushed, so it ends up in level 0.
+	if len(d.levels[numLevels-1]) != 1 || len(d.levels[0]) != 2 {
+		t.Fatalf("unexpected placement: L0=%d, L%d=%d", len(d.levels[0]), numLevels-1, len(d.levels[numLevels-1]))
+	}
+
+	for key, want := range map[string]string{
+		"key0050": "overlapping",
+		"key0120": "overlapping",
+		"key0170": "bottom",
+	} {
+		v, err := d.Get([]byte(key))
+		if err != nil || string(v) != want {
+			t.Fatalf("%s: expected %q, got %q, %v", key, want, v, err)
+		}
+	}
+
+	// Ingested tables survive reopening.
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	r, err := Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	if v, err := r.Get([]byte("key0199")); err != nil || string(v) != "bottom" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+}
+
+func TestIngestRejectsCorruptedFile(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(filepath.Join(dir, "db"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	good := filepath.Join(dir, "good.sst")
+	writeExternalFile(t, good, 0, 10, "good")
+	bad := filepath.Join(dir, "bad.sst")
+	if err = os.WriteFile(bad, []byte("not an sstable"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	if err = d.IngestExternalFiles([]string{good, bad}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err = d.Get([]byte("key0001")); err == nil {
+		t.Fatal("a file of a failed ingestion became visible")
+	}
+}
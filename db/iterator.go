@@ -0,0 +1,267 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// kvIterator is implemented by skiplist.Iterator and sstable.Iterator.
+// Values are returned encoded (see encoder.EncodedValue).
+type kvIterator interface {
+	HasNext() bool
+	Next() ([]byte, []byte)
+}
+
+// sliceIterator iterates over a copy of sorted key-value pairs. It is used
+// to iterate over memtables that may still be modified concurrently.
+type sliceIterator struct {
+	keys, vals [][]byte
+	pos        int
+}
+
+func newSliceIterator(i kvIterator) *sliceIterator {
+	si := &sliceIterator{}
+	for i.HasNext() {
+		key, val := i.Next()
+		si.keys = append(si.keys, key)
+		si.vals = append(si.vals, val)
+	}
+	return si
+}
+
+func (si *sliceIterator) HasNext() bool {
+	return si.pos < len(si.keys)
+}
+
+func (si *sliceIterator) Next() ([]byte, []byte) {
+	if !si.HasNext() {
+		return nil, nil
+	}
+	key, val := si.keys[si.pos], si.vals[si.pos]
+	si.pos++
+
+	return key, val
+}
+
+// mergingIterator merges several sorted iterators into one. When the same
+// key is present in more than one of them, the value from the iterator that
+// comes first wins, so iterators must be ordered from newest to oldest.
+type mergingIterator struct {
+	cmp   comparer.Comparer
+	iters []kvIterator
+	keys  [][]byte // current key-value pair of every iterator
+	vals  [][]byte
+	valid []bool // false once the iterator is exhausted
+}
+
+func newMergingIterator(cmp comparer.Comparer, iters ...kvIterator) *mergingIterator {
+	mi := &mergingIterator{
+		cmp:   cmp,
+		iters: iters,
+		keys:  make([][]byte, len(iters)),
+		vals:  make([][]byte, len(iters)),
+		valid: make([]bool, len(iters)),
+	}
+	for i := range iters {
+		mi.advance(i)
+	}
+	return mi
+}
+
+func (mi *mergingIterator) advance(i int) {
+	mi.valid[i] = mi.iters[i].HasNext()
+	if mi.valid[i] {
+		mi.keys[i], mi.vals[i] = mi.iters[i].Next()
+	}
+}
+
+func (mi *mergingIterator) HasNext() bool {
+	for _, valid := range mi.valid {
+		if valid {
+			return true
+		}
+	}
+	return false
+}
+
+func (mi *mergingIterator) Next() ([]byte, []byte) {
+	smallest := -1
+	for i, key := range mi.keys {
+		if !mi.valid[i] {
+			continue
+		}
+		if smallest < 0 || mi.cmp.Compare(key, mi.keys[smallest]) < 0 {
+			smallest = i
+		}
+	}
+	if smallest < 0 {
+		return nil, nil
+	}
+	key, val := mi.keys[smallest], mi.vals[smallest]
+
+	// Skip older versions of the same key.
+	for i := range mi.keys {
+		if mi.valid[i] && mi.cmp.Compare(mi.keys[i], key) == 0 {
+			mi.advance(i)
+		}
+	}
+	return key, val
+}
+
+// Iterator iterates over the live keys of the DB in ascending order.
+type Iterator struct {
+	db      *DB
+	version *version // keeps the tables being read from being deleted
+	iter    *mergingIterator
+	tables  []*sstable.Iterator
+	readers []*sstable.Reader
+	encoder *encoder.Encoder
+
+	key, val []byte // next live key-value pair
+	hasNext  bool
+	reads    map[string]struct{} // keys returned so far; tracked for transactions
+	ctx      context.Context
+	ctxErr   error // set once iteration is stopped by ctx
+}
+
+// NewIterator returns an iterator over a consistent view of the DB taken
+// at the time of the call. The iterator must be closed after use.
+func (d *DB) NewIterator() (*Iterator, error) {
+	return d.newIterator(context.Background(), nil)
+}
+
+// NewIteratorContext is like NewIterator, but the iterator stops once ctx
+// is done, before reading the next entry, and Err returns ctx.Err().
+func (d *DB) NewIteratorContext(ctx context.Context) (*Iterator, error) {
+	return d.newIterator(ctx, nil)
+}
+
+// Creates a DB iterator; writes (if not nil) take precedence over the DB
+// contents.
+func (d *DB) newIterator(ctx context.Context, writes kvIterator) (*Iterator, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	it := &Iterator{db: d, ctx: ctx}
+	var iters []kvIterator
+	if writes != nil {
+		iters = append(iters, writes)
+	}
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	for i := len(d.memtables.queue) - 1; i >= 0; i-- {
+		m := d.memtables.queue[i]
+		if m == d.memtables.mutable {
+			// The mutable memtable keeps changing, so iterate over its copy.
+			iters = append(iters, newSliceIterator(m.Iterator()))
+			continue
+		}
+		iters = append(iters, m.Iterator())
+	}
+	it.version = d.refVersion()
+	d.mu.Unlock()
+
+	for _, t := range allTables(&it.version.levels) {
+		if err := ctx.Err(); err != nil {
+			it.Close()
+			return nil, err
+		}
+		f, err := d.dataStorage.OpenFileForReading(t.FileMetadata)
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		r, err := sstable.NewReader(f, d.cmp)
+		if err != nil {
+			f.Close()
+			it.Close()
+			return nil, err
+		}
+		it.readers = append(it.readers, r)
+
+		ti, err := r.Iterator()
+		if err != nil {
+			it.Close()
+			return nil, err
+		}
+		it.tables = append(it.tables, ti)
+		iters = append(iters, ti)
+	}
+
+	it.iter = newMergingIterator(d.cmp, iters...)
+	it.findNext()
+
+	return it, nil
+}
+
+// Positions the iterator on the next key that is not deleted.
+func (it *Iterator) findNext() {
+	it.hasNext = false
+	for it.iter.HasNext() {
+		if err := it.ctx.Err(); err != nil {
+			it.ctxErr = err
+			return
+		}
+		key, val := it.iter.Next()
+		encodedValue := it.encoder.Parse(val)
+		if encodedValue.IsTombstone() {
+			continue
+		}
+		it.key, it.val, it.hasNext = key, encodedValue.Value(), true
+		return
+	}
+}
+
+func (it *Iterator) HasNext() bool {
+	return it.hasNext
+}
+
+func (it *Iterator) Next() ([]byte, []byte) {
+	if !it.hasNext {
+		return nil, nil
+	}
+	key, val := it.key, it.val
+	if it.reads != nil {
+		it.reads[string(key)] = struct{}{}
+	}
+	it.findNext()
+
+	return key, val
+}
+
+// Err returns the first error encountered while reading sstables, or the
+// error of the context that stopped the iteration.
+func (it *Iterator) Err() error {
+	if it.ctxErr != nil {
+		return it.ctxErr
+	}
+	for _, t := range it.tables {
+		if err := t.Err(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (it *Iterator) Close() error {
+	var errs []error
+	for _, r := range it.readers {
+		errs = append(errs, r.Close())
+	}
+	it.readers, it.tables = nil, nil
+	it.hasNext = false
+	if it.version != nil {
+		it.db.releaseVersion(it.version)
+		it.version = nil
+	}
+
+	return errors.Join(errs...)
+}
@@ -0,0 +1,118 @@
+package db
+
+import (
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// JobKind tells flushes and compactions apart.
+type JobKind int
+
+const (
+	JobFlush JobKind = iota
+	JobCompaction
+)
+
+func (k JobKind) String() string {
+	if k == JobFlush {
+		return "flush"
+	}
+	return "compaction"
+}
+
+// Job is a flush or compaction running in the background.
+type Job struct {
+	kind    JobKind
+	start   time.Time
+	done    chan struct{}
+	stats   JobStats // final stats, set once done is closed
+	err     error
+	counter struct {
+		tablesRead, tablesWritten atomic.Int64
+		bytesRead, bytesWritten   atomic.Int64
+	}
+}
+
+// JobStats describes the work done by a Job. Flushes read memtables,
+// compactions read sstables.
+type JobStats struct {
+	Kind          JobKind
+	TablesRead    int
+	TablesWritten int
+	BytesRead     int64
+	BytesWritten  int64
+	Duration      time.Duration
+}
+
+func (s JobStats) String() string {
+	return fmt.Sprintf("%s: read %d tables (%d bytes), wrote %d tables (%d bytes) in %s",
+		s.Kind, s.TablesRead, s.BytesRead, s.TablesWritten, s.BytesWritten, s.Duration.Round(time.Millisecond))
+}
+
+func newJob(kind JobKind) *Job {
+	return &Job{kind: kind, start: time.Now(), done: make(chan struct{})}
+}
+
+// Runs fn in the background and completes the job with its result.
+func (d *DB) runJob(j *Job, fn func() error) {
+	d.bgWG.Add(1)
+	go func() {
+		defer d.bgWG.Done()
+		err := fn()
+		j.stats, j.err = j.Progress(), err
+		close(j.done)
+	}()
+}
+
+// Completes a job that could not be started.
+func (j *Job) fail(err error) {
+	j.stats, j.err = j.Progress(), err
+	close(j.done)
+}
+
+// Done is closed once the job has finished.
+func (j *Job) Done() <-chan struct{} {
+	return j.done
+}
+
+// Wait blocks until the job has finished and returns its stats and error.
+func (j *Job) Wait() (JobStats, error) {
+	<-j.done
+	return j.stats, j.err
+}
+
+// Progress returns the work done by the job so far.
+func (j *Job) Progress() JobStats {
+	select {
+	case <-j.done:
+		return j.stats
+	default:
+	}
+	return JobStats{
+		Kind:          j.kind,
+		TablesRead:    int(j.counter.tablesRead.Load()),
+		TablesWritten: int(j.counter.tablesWritten.Load()),
+		BytesRead:     j.counter.bytesRead.Load(),
+		BytesWritten:  j.counter.bytesWritten.Load(),
+		Duration:      time.Since(j.start),
+	}
+}
+
+// Records that the job has read a table. Safe to call on a nil job, which
+// stands for automatic flushes nobody waits for.
+func (j *Job) tableRead(size int64) {
+	if j == nil {
+		return
+	}
+	j.counter.tablesRead.Add(1)
+	j.counter.bytesRead.Add(size)
+}
+
+func (j *Job) tableWritten(size int64) {
+	if j == nil {
+		return
+	}
+	j.counter.tablesWritten.Add(1)
+	j.counter.bytesWritten.Add(size)
+}
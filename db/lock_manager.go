@@ -0,0 +1,112 @@
+package db
+
+import (
+	"errors"
+	"sync"
+	"time"
+)
+
+var (
+	ErrDeadlock    = errors.New("deadlock detected")
+	ErrLockTimeout = errors.New("timed out waiting for key lock")
+)
+
+const defaultLockTimeout = time.Second
+
+type keyLock struct {
+	owner    uint64        // id of the transaction holding the lock
+	released chan struct{} // closed once the lock is released
+}
+
+type waitEdge struct {
+	owner uint64 // transaction that is waited for
+	key   string // key it holds
+}
+
+// lockManager hands out exclusive per-key locks to pessimistic transactions
+// and detects cycles in the wait-for graph.
+type lockManager struct {
+	mu      sync.Mutex
+	locks   map[string]*keyLock
+	waitFor map[uint64]waitEdge // waiting transaction -> lock holder
+}
+
+func newLockManager() *lockManager {
+	return &lockManager{
+		locks:   make(map[string]*keyLock),
+		waitFor: make(map[uint64]waitEdge),
+	}
+}
+
+// Acquires the lock on key for the transaction txnID. A negative timeout
+// waits for as long as it takes.
+func (lm *lockManager) lock(txnID uint64, key string, timeout time.Duration) error {
+	var expired <-chan time.Time
+	if timeout >= 0 {
+		timer := time.NewTimer(timeout)
+		defer timer.Stop()
+		expired = timer.C
+	}
+
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+	defer delete(lm.waitFor, txnID)
+
+	for {
+		l, ok := lm.locks[key]
+		if !ok {
+			lm.locks[key] = &keyLock{owner: txnID, released: make(chan struct{})}
+			return nil
+		}
+		if l.owner == txnID {
+			return nil
+		}
+		if lm.wouldDeadlock(txnID, l.owner) {
+			return ErrDeadlock
+		}
+		lm.waitFor[txnID] = waitEdge{owner: l.owner, key: key}
+
+		lm.mu.Unlock()
+		select {
+		case <-l.released:
+			lm.mu.Lock()
+		case <-expired:
+			lm.mu.Lock()
+			return ErrLockTimeout
+		}
+	}
+}
+
+// Reports whether waiting for holder would close a cycle through waiter.
+func (lm *lockManager) wouldDeadlock(waiter, holder uint64) bool {
+	for i := 0; i <= len(lm.waitFor); i++ {
+		if holder == waiter {
+			return true
+		}
+		edge, ok := lm.waitFor[holder]
+		if !ok {
+			return false
+		}
+		holder = edge.owner
+	}
+	return false
+}
+
+func (lm *lockManager) unlock(txnID uint64, key string) {
+	lm.mu.Lock()
+	defer lm.mu.Unlock()
+
+	l, ok := lm.locks[key]
+	if !ok || l.owner != txnID {
+		return
+	}
+	delete(lm.locks, key)
+	close(l.released)
+
+	// Waiters of this lock no longer wait for its former holder.
+	for waiter, edge := range lm.waitFor {
+		if edge.key == key {
+			delete(lm.waitFor, waiter)
+		}
+	}
+}
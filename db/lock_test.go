@@ -0,0 +1,26 @@
+package db
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestOpenLocksDirectory(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Open(dir, nil); !errors.Is(err, ErrLocked) {
+		t.Fatalf("expected second open to fail with ErrLocked, got %v", err)
+	}
+
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatalf("expected open after close to succeed, got %v", err)
+	}
+	d.Close()
+}
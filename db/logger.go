@@ -0,0 +1,59 @@
+package db
+
+import "log"
+
+// Logger receives diagnostic messages of the DB, such as the results of
+// flushes and compactions. Messages never include keys or values.
+type Logger interface {
+	Debugf(format string, args ...any)
+	Infof(format string, args ...any)
+	Errorf(format string, args ...any)
+}
+
+// LogLevel is the severity of a log message.
+type LogLevel int
+
+const (
+	LogLevelDebug LogLevel = iota
+	LogLevelInfo
+	LogLevelError
+)
+
+func (l LogLevel) String() string {
+	switch l {
+	case LogLevelDebug:
+		return "DEBUG"
+	case LogLevelInfo:
+		return "INFO"
+	}
+	return "ERROR"
+}
+
+// NewStdLogger returns a Logger passing messages of at least the given level
+// to l.
+func NewStdLogger(l *log.Logger, level LogLevel) Logger {
+	return &stdLogger{l: l, level: level}
+}
+
+type stdLogger struct {
+	l     *log.Logger
+	level LogLevel
+}
+
+func (l *stdLogger) Debugf(format string, args ...any) { l.logf(LogLevelDebug, format, args) }
+func (l *stdLogger) Infof(format string, args ...any)  { l.logf(LogLevelInfo, format, args) }
+func (l *stdLogger) Errorf(format string, args ...any) { l.logf(LogLevelError, format, args) }
+
+func (l *stdLogger) logf(level LogLevel, format string, args []any) {
+	if level < l.level {
+		return
+	}
+	l.l.Printf(level.String()+" "+format, args...)
+}
+
+// Discards every message; the default Logger.
+type nopLogger struct{}
+
+func (nopLogger) Debugf(string, ...any) {}
+func (nopLogger) Infof(string, ...any)  {}
+func (nopLogger) Errorf(string, ...any) {}
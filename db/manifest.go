@@ -0,0 +1,156 @@
+package db
+
+import (
+	"encoding/binary"
+	"errors"
+	"hash/crc32"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+var ErrCorruptedManifest = errors.New("corrupted manifest")
+
+const (
+	manifestVersionTables   = 1 // file numbers only, every table in level 0
+	manifestVersionLevels   = 2 // level, size and key range of every table
+	manifestVersionComparer = 3 // name of the comparer
+	manifestVersionLogNum   = 4 // oldest WAL that has not been flushed
+)
+
+// manifest records which sstables make up the DB. Files in the data
+// directory that are not listed in it are not part of the DB.
+//
+// Layout: [version][len][comparer][nextFileNum][logNum][numTables]{table}...[crc32],
+// where a table is [level][fileNum][size][len][smallest][len][largest]. All
+// fields except the little-endian checksum and strings are uvarints.
+type manifest struct {
+	comparer    string
+	nextFileNum int
+	logNum      int // WALs with smaller numbers hold only flushed writes
+	levels      [numLevels][]*tableMeta
+}
+
+func (m *manifest) encode() []byte {
+	var numTables int
+	for _, tables := range m.levels {
+		numTables += len(tables)
+	}
+
+	buf := make([]byte, 0, 64*(numTables+1))
+	buf = binary.AppendUvarint(buf, manifestVersionLogNum)
+	buf = binary.AppendUvarint(buf, uint64(len(m.comparer)))
+	buf = append(buf, m.comparer...)
+	buf = binary.AppendUvarint(buf, uint64(m.nextFileNum))
+	buf = binary.AppendUvarint(buf, uint64(m.logNum))
+	buf = binary.AppendUvarint(buf, uint64(numTables))
+	for level, tables := range m.levels {
+		for _, t := range tables {
+			buf = binary.AppendUvarint(buf, uint64(level))
+			buf = binary.AppendUvarint(buf, uint64(t.FileNum()))
+			buf = binary.AppendUvarint(buf, uint64(t.size))
+			buf = binary.AppendUvarint(buf, uint64(len(t.smallest)))
+			buf = append(buf, t.smallest...)
+			buf = binary.AppendUvarint(buf, uint64(len(t.largest)))
+			buf = append(buf, t.largest...)
+		}
+	}
+	return binary.LittleEndian.AppendUint32(buf, crc32.ChecksumIEEE(buf))
+}
+
+// Decodes a manifest. Tables recorded by the first manifest version come
+// without size and key range, which must be read from the tables.
+func decodeManifest(buf []byte) (*manifest, error) {
+	if len(buf) < 4 {
+		return nil, ErrCorruptedManifest
+	}
+	body, checksum := buf[:len(buf)-4], binary.LittleEndian.Uint32(buf[len(buf)-4:])
+	if crc32.ChecksumIEEE(body) != checksum {
+		return nil, ErrCorruptedManifest
+	}
+
+	d := manifestDecoder{buf: body}
+	version := d.uvarint()
+	if version < manifestVersionTables || version > manifestVersionLogNum {
+		return nil, ErrCorruptedManifest
+	}
+	// Earlier versions were always ordered bytewise.
+	m := &manifest{comparer: comparer.Bytewise.Name()}
+	if version >= manifestVersionComparer {
+		m.comparer = string(d.bytes())
+	}
+	m.nextFileNum = int(d.uvarint())
+	if version >= manifestVersionLogNum {
+		m.logNum = int(d.uvarint())
+	}
+	numTables := int(d.uvarint())
+	for i := 0; i < numTables && d.err == nil; i++ {
+		if version == manifestVersionTables {
+			fileNum := int(d.uvarint())
+			m.levels[0] = append(m.levels[0], &tableMeta{
+				FileMetadata: storage.NewFileMetadata(fileNum, storage.FileTypeSSTable),
+			})
+			continue
+		}
+
+		level := int(d.uvarint())
+		t := &tableMeta{FileMetadata: storage.NewFileMetadata(int(d.uvarint()), storage.FileTypeSSTable)}
+		t.size = int64(d.uvarint())
+		t.smallest = d.bytes()
+		t.largest = d.bytes()
+		if level >= numLevels {
+			return nil, ErrCorruptedManifest
+		}
+		m.levels[level] = append(m.levels[level], t)
+	}
+	if d.err != nil {
+		return nil, d.err
+	}
+	return m, nil
+}
+
+type manifestDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *manifestDecoder) uvarint() uint64 {
+	if d.err != nil {
+		return 0
+	}
+	v, n := binary.Uvarint(d.buf)
+	if n <= 0 {
+		d.err = ErrCorruptedManifest
+		return 0
+	}
+	d.buf = d.buf[n:]
+	return v
+}
+
+func (d *manifestDecoder) bytes() []byte {
+	n := d.uvarint()
+	if d.err != nil {
+		return nil
+	}
+	if n > uint64(len(d.buf)) {
+		d.err = ErrCorruptedManifest
+		return nil
+	}
+	b := d.buf[:n:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+// Builds the manifest describing the current set of sstables.
+func (d *DB) currentManifest() *manifest {
+	return &manifest{
+		comparer:    d.cmp.Name(),
+		nextFileNum: d.dataStorage.LastFileNum() + 1,
+		logNum:      d.logNum,
+		levels:      d.levels,
+	}
+}
+
+func (d *DB) writeManifest() error {
+	return d.dataStorage.WriteManifest(d.currentManifest().encode())
+}
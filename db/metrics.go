@@ -0,0 +1,206 @@
+package db
+
+import (
+	"fmt"
+	"math/bits"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// Metrics describes the state of the DB and its activity since it was
+// opened. Sstables are read without a block cache and have no bloom
+// filters, so there are no cache hit rates or filter counts; they belong
+// here once those components are added.
+type Metrics struct {
+	Memtables struct {
+		Count     int   // memtables, including the mutable one
+		Size      int64 // bytes in all memtables
+		Immutable int   // memtables waiting to be flushed
+	}
+	Levels [numLevels]LevelMetrics
+
+	Gets         uint64 // calls of Get
+	TablesProbed uint64 // sstables searched by those calls
+
+	BytesFlushed   int64 // bytes written to level 0 by flushes
+	BytesCompacted int64 // bytes written by compactions
+	BytesIngested  int64 // bytes of ingested files
+
+	WALBytes  int64  // bytes appended to the WAL
+	WALWrites uint64 // appends to the WAL, each by a group of writes
+	WALSyncs  uint64 // syncs of the WAL
+
+	GetLatency    Histogram
+	SetLatency    Histogram
+	DeleteLatency Histogram
+}
+
+// LevelMetrics describes the sstables of a level.
+type LevelMetrics struct {
+	NumFiles int
+	Size     int64
+}
+
+// ReadAmp returns the average number of sstables searched per Get.
+func (m *Metrics) ReadAmp() float64 {
+	if m.Gets == 0 {
+		return 0
+	}
+	return float64(m.TablesProbed) / float64(m.Gets)
+}
+
+// WriteAmp returns the number of bytes written to sstables per byte flushed
+// from memtables. Ingested files are counted as flushed.
+func (m *Metrics) WriteAmp() float64 {
+	in := m.BytesFlushed + m.BytesIngested
+	if in == 0 {
+		return 0
+	}
+	return float64(in+m.BytesCompacted) / float64(in)
+}
+
+func (m *Metrics) String() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "memtables: %d (%s), %d immutable\n", m.Memtables.Count, formatBytes(m.Memtables.Size), m.Memtables.Immutable)
+	fmt.Fprintf(&b, "level  files  size\n")
+	for level, l := range m.Levels {
+		fmt.Fprintf(&b, "%5d  %5d  %s\n", level, l.NumFiles, formatBytes(l.Size))
+	}
+	fmt.Fprintf(&b, "read amp: %.2f tables per get\n", m.ReadAmp())
+	fmt.Fprintf(&b, "write amp: %.2f (flushed %s, compacted %s, ingested %s)\n",
+		m.WriteAmp(), formatBytes(m.BytesFlushed), formatBytes(m.BytesCompacted), formatBytes(m.BytesIngested))
+	fmt.Fprintf(&b, "wal: %s in %d writes, %d syncs\n", formatBytes(m.WALBytes), m.WALWrites, m.WALSyncs)
+	fmt.Fprintf(&b, "get:    %s\n", m.GetLatency)
+	fmt.Fprintf(&b, "set:    %s\n", m.SetLatency)
+	fmt.Fprintf(&b, "delete: %s\n", m.DeleteLatency)
+	return b.String()
+}
+
+func formatBytes(n int64) string {
+	const unit = 1 << 10
+	if n < unit {
+		return fmt.Sprintf("%d B", n)
+	}
+	div, exp := int64(unit), 0
+	for n/div >= unit && exp < 3 {
+		div *= unit
+		exp++
+	}
+	return fmt.Sprintf("%.1f %ciB", float64(n)/float64(div), "KMGT"[exp])
+}
+
+// Metrics returns a snapshot of the metrics of the DB.
+func (d *DB) Metrics() *Metrics {
+	m := &Metrics{}
+
+	d.mu.Lock()
+	m.Memtables.Count = len(d.memtables.queue)
+	m.Memtables.Immutable = len(d.memtables.queue) - 1
+	for _, mt := range d.memtables.queue {
+		m.Memtables.Size += int64(mt.Size())
+	}
+	for level, tables := range d.levels {
+		m.Levels[level].NumFiles = len(tables)
+		for _, t := range tables {
+			m.Levels[level].Size += t.size
+		}
+	}
+	d.mu.Unlock()
+
+	m.Gets = d.metrics.gets.Load()
+	m.TablesProbed = d.metrics.tablesProbed.Load()
+	m.BytesFlushed = d.metrics.bytesFlushed.Load()
+	m.BytesCompacted = d.metrics.bytesCompacted.Load()
+	m.BytesIngested = d.metrics.bytesIngested.Load()
+	m.WALBytes = d.metrics.walBytes.Load()
+	m.WALWrites = d.metrics.walWrites.Load()
+	m.WALSyncs = d.metrics.walSyncs.Load()
+	m.GetLatency = d.metrics.get.snapshot()
+	m.SetLatency = d.metrics.set.snapshot()
+	m.DeleteLatency = d.metrics.delete.snapshot()
+	return m
+}
+
+type metrics struct {
+	gets, tablesProbed                          atomic.Uint64
+	bytesFlushed, bytesCompacted, bytesIngested atomic.Int64
+	walBytes                                    atomic.Int64
+	walWrites, walSyncs                         atomic.Uint64
+	get, set, delete                            latencyHistogram
+}
+
+// Bucket i of a latency histogram counts durations of less than
+// 2^i microseconds; the last bucket counts everything longer.
+const numLatencyBuckets = 32
+
+// Histogram is a snapshot of the latencies of an operation. The buckets are
+// the same for every histogram; the last one has no upper bound.
+type Histogram struct {
+	Count   uint64
+	Sum     time.Duration
+	Buckets []HistogramBucket
+}
+
+// HistogramBucket counts the latencies up to UpperBound that are larger than
+// the bound of the previous bucket.
+type HistogramBucket struct {
+	UpperBound time.Duration
+	Count      uint64
+}
+
+// Mean returns the average latency.
+func (h Histogram) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// Percentile returns the upper bound of the bucket holding the p-th
+// percentile, 0 < p <= 100, of the latencies.
+func (h Histogram) Percentile(p float64) time.Duration {
+	var total uint64
+	for _, b := range h.Buckets {
+		total += b.Count
+	}
+	rank := uint64(float64(total)*p/100 + 0.5)
+	var seen uint64
+	for _, b := range h.Buckets {
+		seen += b.Count
+		if seen >= max(rank, 1) {
+			return b.UpperBound
+		}
+	}
+	return 0
+}
+
+func (h Histogram) String() string {
+	return fmt.Sprintf("count %d, mean %s, p50 %s, p99 %s", h.Count, h.Mean(), h.Percentile(50), h.Percentile(99))
+}
+
+type latencyHistogram struct {
+	count, sum atomic.Uint64
+	buckets    [numLatencyBuckets]atomic.Uint64
+}
+
+func (h *latencyHistogram) record(d time.Duration) {
+	d = max(d, 0)
+	i := min(bits.Len64(uint64(d/time.Microsecond)), numLatencyBuckets-1)
+	h.buckets[i].Add(1)
+	h.count.Add(1)
+	h.sum.Add(uint64(d))
+}
+
+func (h *latencyHistogram) snapshot() Histogram {
+	s := Histogram{Count: h.count.Load(), Sum: time.Duration(h.sum.Load())}
+	for i := range h.buckets {
+		n := h.buckets[i].Load()
+		bound := time.Duration(1<<i) * time.Microsecond
+		if i == numLatencyBuckets-1 {
+			bound = time.Duration(1<<63 - 1)
+		}
+		s.Buckets = append(s.Buckets, HistogramBucket{UpperBound: bound, Count: n})
+	}
+	return s
+}
@@ -0,0 +1,188 @@
+// Package metrics exports the metrics of a DB through expvar and in the
+// Prometheus text exposition format.
+package metrics
+
+import (
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/db"
+)
+
+// The DB has a single column family, which every metric is labeled with.
+const columnFamily = "default"
+
+// ErrNameTaken is returned by Publish for a name that is already published.
+var ErrNameTaken = errors.New("metrics: expvar name is already published")
+
+// Serializes Publish, so that checking a name and publishing it cannot race.
+var publishMu sync.Mutex
+
+// Publish registers the metrics of d with expvar under name. It fails with
+// ErrNameTaken if name is already published; expvar names cannot be
+// unregistered, so each name can be used once per process.
+func Publish(name string, d *db.DB) error {
+	publishMu.Lock()
+	defer publishMu.Unlock()
+
+	if expvar.Get(name) != nil {
+		return fmt.Errorf("%w: %q", ErrNameTaken, name)
+	}
+	expvar.Publish(name, expvar.Func(func() any {
+		return expvarMetrics(d)
+	}))
+	return nil
+}
+
+func expvarMetrics(d *db.DB) map[string]any {
+	m := d.Metrics()
+	stalls := d.WriteStallStats()
+
+	levels := make([]map[string]any, len(m.Levels))
+	for level, l := range m.Levels {
+		levels[level] = map[string]any{"files": l.NumFiles, "bytes": l.Size}
+	}
+	return map[string]any{
+		"column_family":         columnFamily,
+		"memtables":             m.Memtables.Count,
+		"memtable_bytes":        m.Memtables.Size,
+		"immutable_memtables":   m.Memtables.Immutable,
+		"levels":                levels,
+		"gets":                  m.Gets,
+		"tables_probed":         m.TablesProbed,
+		"read_amp":              m.ReadAmp(),
+		"flushed_bytes":         m.BytesFlushed,
+		"compacted_bytes":       m.BytesCompacted,
+		"ingested_bytes":        m.BytesIngested,
+		"write_amp":             m.WriteAmp(),
+		"wal_bytes":             m.WALBytes,
+		"wal_writes":            m.WALWrites,
+		"wal_syncs":             m.WALSyncs,
+		"write_stall_slowdowns": stalls.Slowdowns,
+		"write_stall_stops":     stalls.Stops,
+		"write_stall_seconds":   stalls.Duration.Seconds(),
+		"get_latency":           expvarHistogram(m.GetLatency),
+		"set_latency":           expvarHistogram(m.SetLatency),
+		"delete_latency":        expvarHistogram(m.DeleteLatency),
+	}
+}
+
+func expvarHistogram(h db.Histogram) map[string]any {
+	return map[string]any{
+		"count":        h.Count,
+		"mean_seconds": h.Mean().Seconds(),
+		"p50_seconds":  h.Percentile(50).Seconds(),
+		"p99_seconds":  h.Percentile(99).Seconds(),
+	}
+}
+
+// Handler returns a handler serving the metrics of d in the Prometheus text
+// exposition format.
+func Handler(d *db.DB) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+		WritePrometheus(w, d)
+	})
+}
+
+// WritePrometheus writes the metrics of d to w in the Prometheus text
+// exposition format.
+func WritePrometheus(w io.Writer, d *db.DB) error {
+	m := d.Metrics()
+	stalls := d.WriteStallStats()
+	p := &promWriter{w: w}
+
+	p.header("lsm_memtables", "gauge", "Memtables, including the mutable one.")
+	p.sample("lsm_memtables", nil, float64(m.Memtables.Count))
+	p.header("lsm_memtable_bytes", "gauge", "Bytes in all memtables.")
+	p.sample("lsm_memtable_bytes", nil, float64(m.Memtables.Size))
+	p.header("lsm_immutable_memtables", "gauge", "Memtables waiting to be flushed.")
+	p.sample("lsm_immutable_memtables", nil, float64(m.Memtables.Immutable))
+
+	p.header("lsm_level_files", "gauge", "Sstables per level.")
+	for level, l := range m.Levels {
+		p.sample("lsm_level_files", []string{"level", strconv.Itoa(level)}, float64(l.NumFiles))
+	}
+	p.header("lsm_level_bytes", "gauge", "Bytes of sstables per level.")
+	for level, l := range m.Levels {
+		p.sample("lsm_level_bytes", []string{"level", strconv.Itoa(level)}, float64(l.Size))
+	}
+
+	counters := []struct {
+		name, help string
+		value      float64
+	}{
+		{"lsm_gets_total", "Calls of Get.", float64(m.Gets)},
+		{"lsm_tables_probed_total", "Sstables searched by Get.", float64(m.TablesProbed)},
+		{"lsm_flushed_bytes_total", "Bytes written to level 0 by flushes.", float64(m.BytesFlushed)},
+		{"lsm_compacted_bytes_total", "Bytes written by compactions.", float64(m.BytesCompacted)},
+		{"lsm_ingested_bytes_total", "Bytes of ingested files.", float64(m.BytesIngested)},
+		{"lsm_wal_bytes_total", "Bytes appended to the WAL.", float64(m.WALBytes)},
+		{"lsm_wal_writes_total", "Appends to the WAL, each by a group of writes.", float64(m.WALWrites)},
+		{"lsm_wal_syncs_total", "Syncs of the WAL.", float64(m.WALSyncs)},
+		{"lsm_write_stall_slowdowns_total", "Writes delayed by a write stall.", float64(stalls.Slowdowns)},
+		{"lsm_write_stall_stops_total", "Writes blocked by a write stall.", float64(stalls.Stops)},
+		{"lsm_write_stall_seconds_total", "Time spent in write stalls.", stalls.Duration.Seconds()},
+	}
+	for _, c := range counters {
+		p.header(c.name, "counter", c.help)
+		p.sample(c.name, nil, c.value)
+	}
+
+	p.histogram("lsm_get_latency_seconds", "Latency of Get.", m.GetLatency)
+	p.histogram("lsm_set_latency_seconds", "Latency of Set.", m.SetLatency)
+	p.histogram("lsm_delete_latency_seconds", "Latency of Delete.", m.DeleteLatency)
+	return p.err
+}
+
+// Writes metrics in the text exposition format, remembering the first error.
+type promWriter struct {
+	w   io.Writer
+	err error
+}
+
+func (p *promWriter) printf(format string, args ...any) {
+	if p.err == nil {
+		_, p.err = fmt.Fprintf(p.w, format, args...)
+	}
+}
+
+func (p *promWriter) header(name, typ, help string) {
+	p.printf("# HELP %s %s\n# TYPE %s %s\n", name, help, name, typ)
+}
+
+// Writes a sample labeled with the column family and the given label
+// name-value pairs.
+func (p *promWriter) sample(name string, labels []string, value float64) {
+	p.printf("%s{cf=%q", name, columnFamily)
+	for i := 0; i+1 < len(labels); i += 2 {
+		p.printf(",%s=%q", labels[i], labels[i+1])
+	}
+	p.printf("} %s\n", formatFloat(value))
+}
+
+func (p *promWriter) histogram(name, help string, h db.Histogram) {
+	p.header(name, "histogram", help)
+	var cumulative uint64
+	for _, b := range h.Buckets {
+		cumulative += b.Count
+		le := "+Inf"
+		if b.UpperBound != time.Duration(math.MaxInt64) {
+			le = formatFloat(b.UpperBound.Seconds())
+		}
+		p.sample(name+"_bucket", []string{"le", le}, float64(cumulative))
+	}
+	p.sample(name+"_sum", nil, h.Sum.Seconds())
+	p.sample(name+"_count", nil, float64(cumulative))
+}
+
+func formatFloat(v float64) string {
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,86 @@
+package metrics
+
+import (
+	"encoding/json"
+	"errors"
+	"expvar"
+	"fmt"
+	"io"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/db"
+)
+
+func openDB(t *testing.T) *db.DB {
+	d, err := db.Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("a"), []byte("1"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	d.Get([]byte("a"))
+	return d
+}
+
+func TestHandler(t *testing.T) {
+	d := openDB(t)
+	srv := httptest.NewServer(Handler(d))
+	defer srv.Close()
+
+	resp, err := srv.Client().Get(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	out := string(body)
+	for _, want := range []string{
+		"# TYPE lsm_level_files gauge\n",
+		`lsm_level_files{cf="default",level="0"} 1` + "\n",
+		`lsm_gets_total{cf="default"} 1` + "\n",
+		"# TYPE lsm_get_latency_seconds histogram\n",
+		`lsm_get_latency_seconds_bucket{cf="default",le="+Inf"} 1` + "\n",
+		`lsm_set_latency_seconds_count{cf="default"} 1` + "\n",
+	} {
+		if !strings.Contains(out, want) {
+			t.Fatalf("expected %q in output:\n%s", want, out)
+		}
+	}
+}
+
+// Names published by earlier runs of a test stay registered, e.g. with
+// -count.
+var published int
+
+func TestPublish(t *testing.T) {
+	d := openDB(t)
+	published++
+	name := fmt.Sprintf("lsm_test_%s_%d", t.Name(), published)
+	if err := Publish(name, d); err != nil {
+		t.Fatal(err)
+	}
+	if err := Publish(name, d); !errors.Is(err, ErrNameTaken) {
+		t.Fatalf("expected ErrNameTaken for a published name, got %v", err)
+	}
+
+	var m struct {
+		Gets   int `json:"gets"`
+		Levels []struct {
+			Files int `json:"files"`
+		} `json:"levels"`
+	}
+	if err := json.Unmarshal([]byte(expvar.Get(name).String()), &m); err != nil {
+		t.Fatal(err)
+	}
+	if m.Gets != 1 || len(m.Levels) == 0 || m.Levels[0].Files != 1 {
+		t.Fatalf("unexpected expvar metrics %+v", m)
+	}
+}
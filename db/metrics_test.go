@@ -0,0 +1,75 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v"))
+		}
+		if _, err = d.Flush().Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Delete([]byte("key0000"))
+	for i := 0; i < 10; i++ {
+		d.Get([]byte(fmt.Sprintf("key%04d", i+1)))
+	}
+
+	m := d.Metrics()
+	if m.Levels[0].NumFiles != 2 || m.Levels[0].Size == 0 {
+		t.Fatalf("unexpected level 0 metrics %+v", m.Levels[0])
+	}
+	if m.Memtables.Count != 1 || m.Memtables.Immutable != 0 || m.Memtables.Size == 0 {
+		t.Fatalf("unexpected memtable metrics %+v", m.Memtables)
+	}
+	// Both level 0 tables hold every key, the newest one answers.
+	if m.Gets != 10 || m.ReadAmp() != 1 {
+		t.Fatalf("expected 10 gets probing 1 table each, got %d gets, read amp %.2f", m.Gets, m.ReadAmp())
+	}
+	if m.SetLatency.Count != 200 || m.DeleteLatency.Count != 1 || m.GetLatency.Count != 10 {
+		t.Fatalf("unexpected latency counts %d, %d, %d", m.SetLatency.Count, m.DeleteLatency.Count, m.GetLatency.Count)
+	}
+	if m.BytesFlushed != m.Levels[0].Size || m.WriteAmp() != 1 {
+		t.Fatalf("unexpected flush metrics: flushed %d, write amp %.2f", m.BytesFlushed, m.WriteAmp())
+	}
+
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	m = d.Metrics()
+	if m.BytesCompacted == 0 || m.WriteAmp() <= 1 || m.Levels[numLevels-1].NumFiles == 0 {
+		t.Fatalf("unexpected compaction metrics: compacted %d, write amp %.2f", m.BytesCompacted, m.WriteAmp())
+	}
+	if s := m.String(); !strings.Contains(s, "read amp: 1.00") || !strings.Contains(s, "get:    count 10") {
+		t.Fatalf("unexpected String output:\n%s", s)
+	}
+}
+
+func TestHistogramPercentile(t *testing.T) {
+	var h latencyHistogram
+	for i := 0; i < 99; i++ {
+		h.record(3 * time.Microsecond)
+	}
+	h.record(time.Millisecond)
+	s := h.snapshot()
+	if p := s.Percentile(50); p != 4*time.Microsecond {
+		t.Fatalf("expected p50 of 4µs, got %s", p)
+	}
+	if p := s.Percentile(100); p != 1024*time.Microsecond {
+		t.Fatalf("expected p100 of 1024µs, got %s", p)
+	}
+	if s.Count != 100 || s.Mean() != (99*3*time.Microsecond+time.Millisecond)/100 {
+		t.Fatalf("unexpected count %d or mean %s", s.Count, s.Mean())
+	}
+}
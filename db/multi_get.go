@@ -0,0 +1,137 @@
+package db
+
+import (
+	"context"
+	"slices"
+
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// MultiGet looks up several keys at once. The i-th value is the value of
+// keys[i], or nil if the key is not found. Keys resolved by the memtables
+// are not looked up in sstables, and every sstable is opened and every data
+// block read at most once for all keys, unlike with a Get per key.
+func (d *DB) MultiGet(keys [][]byte) ([][]byte, error) {
+	return d.MultiGetContext(context.Background(), keys)
+}
+
+// MultiGetContext is like MultiGet, but gives up with ctx.Err() once ctx is
+// done. The context is checked before every sstable block is read.
+func (d *DB) MultiGetContext(ctx context.Context, keys [][]byte) ([][]byte, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	vals := make([][]byte, len(keys))
+	// Positions in keys of the keys not resolved yet, ordered by key.
+	pending := make([]int, len(keys))
+	for i := range pending {
+		pending[i] = i
+	}
+	slices.SortFunc(pending, func(a, b int) int {
+		return d.cmp.Compare(keys[a], keys[b])
+	})
+
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	d.metrics.gets.Add(uint64(len(keys)))
+	// Scan memtables from newest to oldest.
+	for i := len(d.memtables.queue) - 1; i >= 0 && len(pending) > 0; i-- {
+		m := d.memtables.queue[i]
+		pending = slices.DeleteFunc(pending, func(k int) bool {
+			encodedValue, err := m.Get(keys[k])
+			if err != nil {
+				return false // The only possible error is "key not found".
+			}
+			if !encodedValue.IsTombstone() {
+				vals[k] = found(encodedValue.Value())
+			}
+			return true
+		})
+	}
+	v := d.refVersion()
+	d.mu.Unlock()
+	defer d.releaseVersion(v)
+
+	// Scan sstables from newest to oldest, each for the pending keys in its
+	// key range.
+	for _, t := range allTables(&v.levels) {
+		if len(pending) == 0 {
+			break
+		}
+		first, _ := slices.BinarySearchFunc(pending, t.smallest, func(k int, key []byte) int {
+			return d.cmp.Compare(keys[k], key)
+		})
+		end, _ := slices.BinarySearchFunc(pending, t.largest, func(k int, key []byte) int {
+			return d.cmp.Compare(keys[k], key)
+		})
+		for end < len(pending) && d.cmp.Compare(keys[pending[end]], t.largest) == 0 {
+			end++ // Also the keys equal to the largest one.
+		}
+		if first >= end {
+			continue
+		}
+		// Counted per key, like Get does, so that ReadAmp stays the number of
+		// tables searched per key.
+		d.metrics.tablesProbed.Add(uint64(end - first))
+		resolved, err := d.multiGetFromTable(ctx, t, keys, pending[first:end], vals)
+		if err != nil {
+			return nil, err
+		}
+		rest := make([]int, 0, len(pending))
+		rest = append(rest, pending[:first]...)
+		for _, k := range pending[first:end] {
+			if !resolved[k] {
+				rest = append(rest, k)
+			}
+		}
+		pending = append(rest, pending[end:]...)
+	}
+	return vals, nil
+}
+
+// Looks up the keys at the positions lookup, ordered by key, in a single
+// sstable. Sets the values of the keys found to vals and returns which keys
+// the table resolves, either with a value or deleted.
+func (d *DB) multiGetFromTable(ctx context.Context, t *tableMeta, keys [][]byte, lookup []int, vals [][]byte) (map[int]bool, error) {
+	f, err := d.dataStorage.OpenFileForReading(t.FileMetadata)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sstable.NewReader(f, d.cmp)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer r.Close()
+
+	tableKeys := make([][]byte, len(lookup))
+	for i, k := range lookup {
+		tableKeys[i] = keys[k]
+	}
+	encodedValues, err := r.MultiGet(ctx, tableKeys, d.opts.MultiGetParallelism)
+	if err != nil {
+		return nil, err
+	}
+	resolved := make(map[int]bool)
+	for i, encodedValue := range encodedValues {
+		if encodedValue == nil {
+			continue
+		}
+		resolved[lookup[i]] = true
+		if !encodedValue.IsTombstone() {
+			vals[lookup[i]] = found(encodedValue.Value())
+		}
+	}
+	return resolved, nil
+}
+
+// Returns a non-nil value, which tells an empty value from a missing key.
+func found(val []byte) []byte {
+	if val == nil {
+		return []byte{}
+	}
+	return val
+}
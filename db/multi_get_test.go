@@ -0,0 +1,96 @@
+package db
+
+import (
+	"fmt"
+	"math/rand"
+	"testing"
+)
+
+func TestMultiGet(t *testing.T) {
+	for _, parallelism := range []int{1, 4} {
+		t.Run(fmt.Sprintf("parallelism=%d", parallelism), func(t *testing.T) {
+			d, err := Open(t.TempDir(), &Options{MultiGetParallelism: parallelism})
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(func() { d.Close() })
+
+			// Older versions in the bottom level, newer ones and deletions in
+			// level 0 and in the memtables.
+			for i := 0; i < 1000; i++ {
+				d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("old"))
+			}
+			if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 1000; i += 3 {
+				d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("new"))
+			}
+			for i := 0; i < 1000; i += 7 {
+				d.Delete([]byte(fmt.Sprintf("key%04d", i)))
+			}
+			if _, err = d.Flush().Wait(); err != nil {
+				t.Fatal(err)
+			}
+			for i := 0; i < 1000; i += 11 {
+				d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(""))
+			}
+
+			rng := rand.New(rand.NewSource(1))
+			keys := [][]byte{[]byte("key0000"), []byte("key0000"), []byte("missing")}
+			for i := 0; i < 300; i++ {
+				keys = append(keys, []byte(fmt.Sprintf("key%04d", rng.Intn(1100))))
+			}
+			vals, err := d.MultiGet(keys)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for i, key := range keys {
+				want, err := d.Get(key)
+				if err == ErrNotFound {
+					if vals[i] != nil {
+						t.Fatalf("%s: expected nil, got %q", key, vals[i])
+					}
+					continue
+				}
+				if err != nil {
+					t.Fatal(err)
+				}
+				if vals[i] == nil || string(vals[i]) != string(want) {
+					t.Fatalf("%s: expected %q, got %q", key, want, vals[i])
+				}
+			}
+		})
+	}
+}
+
+func TestMultiGetMetrics(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	var keys [][]byte
+	for i := 0; i < 1000; i++ {
+		key := []byte(fmt.Sprintf("key%04d", i))
+		d.Set(key, []byte("v"))
+		keys = append(keys, key)
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	before := d.Metrics()
+	if _, err = d.MultiGet(keys); err != nil {
+		t.Fatal(err)
+	}
+	after := d.Metrics()
+	if gets := after.Gets - before.Gets; gets != 1000 {
+		t.Fatalf("expected 1000 gets, got %d", gets)
+	}
+	// Like with Get, every key is searched for in the one table that holds
+	// it.
+	if probed := after.TablesProbed - before.TablesProbed; probed != 1000 {
+		t.Fatalf("expected 1000 table probes, got %d", probed)
+	}
+}
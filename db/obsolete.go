@@ -0,0 +1,105 @@
+package db
+
+import (
+	"slices"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+// Queues a file that is no longer part of the DB for deletion by a
+// background goroutine. Files queued after Close are deleted by Close, or
+// by the next Open. Must be called with d.mu held.
+func (d *DB) scheduleDeletion(meta *storage.FileMetadata) {
+	if d.opts.ReadOnly || d.obsolete.pending[meta.FileNum()] {
+		return
+	}
+	if d.obsolete.pending == nil {
+		d.obsolete.pending = make(map[int]bool)
+	}
+	d.obsolete.pending[meta.FileNum()] = true
+	d.obsolete.queue = append(d.obsolete.queue, meta)
+	if d.bg.deleting || d.closed {
+		return
+	}
+	d.bg.deleting = true
+	d.bgWG.Add(1)
+	go func() {
+		defer d.bgWG.Done()
+		d.deleteObsoleteFiles()
+	}()
+}
+
+// Deletes the queued files until none is left. Must be called without d.mu
+// held.
+func (d *DB) deleteObsoleteFiles() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	for len(d.obsolete.queue) > 0 {
+		files := d.obsolete.queue
+		d.obsolete.queue = nil
+		d.mu.Unlock()
+		for _, meta := range files {
+			if meta.IsWAL() {
+				d.deleteWAL(meta)
+			} else {
+				d.deleteTable(meta)
+			}
+		}
+		d.mu.Lock()
+		for _, meta := range files {
+			delete(d.obsolete.pending, meta.FileNum())
+		}
+	}
+	d.bg.deleting = false
+	d.workDone.Broadcast()
+}
+
+// Schedules the deletion of the files left behind by a crash: sstables that
+// the manifest does not reference, such as the outputs of a flush or
+// compaction in progress, and WALs that have been flushed. Must be called
+// with d.mu held.
+func (d *DB) deleteUnreferencedFiles() error {
+	files, err := d.dataStorage.ListFiles()
+	if err != nil {
+		return err
+	}
+	live := make(map[int]bool)
+	for _, t := range allTables(&d.levels) {
+		live[t.FileNum()] = true
+	}
+	for _, f := range files {
+		if f.IsSSTable() && !live[f.FileNum()] || f.IsWAL() && f.FileNum() < d.logNum {
+			d.scheduleDeletion(f)
+		}
+	}
+	return nil
+}
+
+// Marks the start of a job that writes new tables, such as a flush: the
+// tables numbered from the returned number on may be its outputs, which are
+// not obsolete although no version references them yet. Must be called
+// with d.mu held.
+func (d *DB) protectOutputs() int {
+	first := d.dataStorage.LastFileNum() + 1
+	d.obsolete.writing = append(d.obsolete.writing, first)
+	return first
+}
+
+// Marks the end of a job started with protectOutputs. Must be called without
+// d.mu held.
+func (d *DB) releaseOutputs(first int) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	i := slices.Index(d.obsolete.writing, first)
+	d.obsolete.writing = slices.Delete(d.obsolete.writing, i, i+1)
+}
+
+// Returns the smallest number of the tables that running jobs may be
+// writing, or 0 if none is running. Must be called with d.mu held.
+func (d *DB) protectedOutputs() int {
+	if len(d.obsolete.writing) == 0 {
+		return 0
+	}
+	return slices.Min(d.obsolete.writing)
+}
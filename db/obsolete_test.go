@@ -0,0 +1,122 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"sync"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+func TestObsoleteTablesOutliveIterators(t *testing.T) {
+	var mu sync.Mutex
+	var deleted []int
+	opts := &Options{EventListener: &EventListener{
+		TableDeleted: func(info TableDeleteInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			if info.Err != nil {
+				t.Error(info.Err)
+			}
+			deleted = append(deleted, info.FileNum)
+		},
+	}}
+	d, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for round := 0; round < 2; round++ {
+		for i := 0; i < 100; i++ {
+			d.Set([]byte(fmt.Sprintf("key%03d", i)), []byte(fmt.Sprintf("v%d", round)))
+		}
+		if _, err = d.Flush().Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	inputs := slices.Clone(d.levels[0])
+
+	it, err := d.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	waitForBackgroundWork(d)
+	for _, in := range inputs {
+		if _, err = os.Stat(d.dataStorage.Path(in.FileMetadata)); err != nil {
+			t.Fatalf("expected table %d to be kept for the iterator: %v", in.FileNum(), err)
+		}
+	}
+	var n int
+	for ; it.HasNext(); n++ {
+		if _, v := it.Next(); string(v) != "v1" {
+			t.Fatalf("expected %q, got %q", "v1", v)
+		}
+	}
+	if err = it.Err(); err != nil || n != 100 {
+		t.Fatalf("expected 100 keys, got %d, %v", n, err)
+	}
+	if err = it.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	waitForBackgroundWork(d)
+	for _, in := range inputs {
+		if _, err = os.Stat(d.dataStorage.Path(in.FileMetadata)); !os.IsNotExist(err) {
+			t.Fatalf("expected table %d to be deleted, got %v", in.FileNum(), err)
+		}
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(deleted) != len(inputs) {
+		t.Fatalf("expected %d tables deleted, got %v", len(inputs), deleted)
+	}
+}
+
+func TestOpenDeletesLeftovers(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("a"), []byte("1"))
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// A table of a flush that did not finish, and a flushed WAL.
+	before := listDir(t, dir)
+	writeExternalFile(t, filepath.Join(dir, "000500.sst"), 0, 10, "v")
+	staleWAL := d.dataStorage.Path(storage.NewFileMetadata(0, storage.FileTypeWAL))
+	if err = os.WriteFile(staleWAL, nil, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	var tables, wals []int
+	opts := &Options{EventListener: &EventListener{
+		TableDeleted: func(info TableDeleteInfo) { tables = append(tables, info.FileNum) },
+		WALDeleted:   func(info WALDeleteInfo) { wals = append(wals, info.FileNum) },
+	}}
+	d, err = Open(dir, opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := d.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("expected %q, got %q, %v", "1", v, err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	// Open also switched to a new WAL and deleted the previous one.
+	if !slices.Equal(tables, []int{500}) || len(wals) != 2 || wals[0] != 0 {
+		t.Fatalf("expected table 500 and WAL 0 to be deleted, got tables %v, WALs %v", tables, wals)
+	}
+	after := listDir(t, dir)
+	if slices.Contains(after, "000500.sst") || slices.Contains(after, "000000.log") || len(after) != len(before) {
+		t.Fatalf("expected the leftovers to be deleted, got %v, had %v", after, before)
+	}
+}
@@ -0,0 +1,89 @@
+package db
+
+import "github.com/wubba-com/lsm-tree/comparer"
+
+// Options configures a DB.
+type Options struct {
+	// Comparer defines the order of keys. A DB must always be opened with
+	// the comparer it was created with. Defaults to comparer.Bytewise.
+	Comparer comparer.Comparer
+
+	// CompactionFilter, if set, is consulted for every value written by
+	// flushes and compactions.
+	CompactionFilter CompactionFilter
+
+	// TargetFileSize is the size at which compactions start a new output
+	// sstable. Defaults to 2 MiB.
+	TargetFileSize int
+
+	// RateLimiter, if set, throttles the bytes written by flushes and
+	// compactions. It may be adjusted while the DB is open.
+	RateLimiter *RateLimiter
+
+	// Writes are delayed once flushes or compactions fall behind by the
+	// given number of immutable memtables or level 0 tables, and block
+	// until they catch up past the stop thresholds. Default to 4 and 8
+	// immutable memtables, and 8 and 12 level 0 tables.
+	MemtableSlowdownThreshold int
+	MemtableStopThreshold     int
+	L0SlowdownThreshold       int
+	L0StopThreshold           int
+
+	// L0CompactionTrigger is the number of level 0 tables that starts a
+	// background compaction. Defaults to 4 and is capped by L0StopThreshold.
+	L0CompactionTrigger int
+
+	// MultiGetParallelism is the number of data blocks of an sstable that
+	// MultiGet reads at once. Defaults to 1, reading one block after the
+	// other.
+	MultiGetParallelism int
+
+	// EventListener, if set, is notified about background activity.
+	EventListener *EventListener
+
+	// Logger receives diagnostic messages. Defaults to discarding them.
+	Logger Logger
+
+	// ReadOnly opens the DB without modifying its directory in any way:
+	// the directory is neither created nor locked, and writes, flushes and
+	// compactions fail with ErrReadOnly.
+	ReadOnly bool
+}
+
+// Returns a copy of the options with unset fields filled with defaults.
+func (o *Options) withDefaults() *Options {
+	opts := &Options{}
+	if o != nil {
+		*opts = *o
+	}
+	if opts.Comparer == nil {
+		opts.Comparer = comparer.Bytewise
+	}
+	if opts.TargetFileSize <= 0 {
+		opts.TargetFileSize = 2 << 20
+	}
+	if opts.MemtableSlowdownThreshold <= 0 {
+		opts.MemtableSlowdownThreshold = 4
+	}
+	if opts.MemtableStopThreshold <= 0 {
+		opts.MemtableStopThreshold = 8
+	}
+	if opts.L0SlowdownThreshold <= 0 {
+		opts.L0SlowdownThreshold = 8
+	}
+	if opts.L0StopThreshold <= 0 {
+		opts.L0StopThreshold = 12
+	}
+	if opts.L0CompactionTrigger <= 0 {
+		opts.L0CompactionTrigger = 4
+	}
+	if opts.MultiGetParallelism <= 0 {
+		opts.MultiGetParallelism = 1
+	}
+	if opts.Logger == nil {
+		opts.Logger = nopLogger{}
+	}
+	// Otherwise writes would wait for a compaction that never starts.
+	opts.L0CompactionTrigger = min(opts.L0CompactionTrigger, opts.L0StopThreshold)
+	return opts
+}
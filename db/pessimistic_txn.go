@@ -0,0 +1,130 @@
+package db
+
+import (
+	"context"
+	"time"
+)
+
+type PessimisticTxnOptions struct {
+	// LockTimeout is how long to wait for a key lock held by another
+	// transaction. Zero means one second, negative means wait forever.
+	LockTimeout time.Duration
+}
+
+// PessimisticTxn is a transaction that takes an exclusive lock on every key
+// it writes or reads with GetForUpdate. Locks are held until Commit or
+// Rollback, and buffered writes are applied to the DB as one atomic batch
+// at commit. After a lock error (ErrDeadlock or ErrLockTimeout) the
+// transaction should be rolled back.
+type PessimisticTxn struct {
+	db          *DB
+	id          uint64
+	lockTimeout time.Duration
+	writes      *writeBuffer
+	locked      map[string]struct{}
+	closed      bool
+}
+
+func (d *DB) BeginPessimisticTxn(opts *PessimisticTxnOptions) *PessimisticTxn {
+	t := &PessimisticTxn{
+		db:          d,
+		id:          d.nextTxnID.Add(1),
+		lockTimeout: defaultLockTimeout,
+		writes:      newWriteBuffer(d.cmp),
+		locked:      make(map[string]struct{}),
+	}
+	if opts != nil && opts.LockTimeout != 0 {
+		t.lockTimeout = opts.LockTimeout
+	}
+	return t
+}
+
+func (t *PessimisticTxn) lock(key []byte) error {
+	if _, ok := t.locked[string(key)]; ok {
+		return nil
+	}
+	err := t.db.locks.lock(t.id, string(key), t.lockTimeout)
+	if err != nil {
+		return err
+	}
+	t.locked[string(key)] = struct{}{}
+
+	return nil
+}
+
+func (t *PessimisticTxn) unlockAll() {
+	for key := range t.locked {
+		t.db.locks.unlock(t.id, key)
+	}
+	t.locked = nil
+}
+
+// Get reads key without locking it.
+func (t *PessimisticTxn) Get(key []byte) ([]byte, error) {
+	if t.closed {
+		return nil, ErrTxnClosed
+	}
+	return t.writes.read(t.db, key)
+}
+
+// GetForUpdate locks key and reads it, so that no other transaction can
+// modify it until this one finishes.
+func (t *PessimisticTxn) GetForUpdate(key []byte) ([]byte, error) {
+	if t.closed {
+		return nil, ErrTxnClosed
+	}
+	if err := t.lock(key); err != nil {
+		return nil, err
+	}
+	return t.writes.read(t.db, key)
+}
+
+func (t *PessimisticTxn) Set(key, val []byte) error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	if err := t.lock(key); err != nil {
+		return err
+	}
+	t.writes.set(key, val)
+	return nil
+}
+
+func (t *PessimisticTxn) Delete(key []byte) error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	if err := t.lock(key); err != nil {
+		return err
+	}
+	t.writes.delete(key)
+	return nil
+}
+
+// NewIterator returns an iterator over the DB that also sees the writes made
+// by the transaction so far. Iterated keys are not locked.
+func (t *PessimisticTxn) NewIterator() (*Iterator, error) {
+	if t.closed {
+		return nil, ErrTxnClosed
+	}
+	return t.db.newIterator(context.Background(), newSliceIterator(t.writes.sl.Iterator()))
+}
+
+func (t *PessimisticTxn) Commit() error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.closed = true
+	defer t.unlockAll()
+
+	return t.db.Apply(t.writes.batch())
+}
+
+// Rollback discards all writes of the transaction and releases its locks.
+func (t *PessimisticTxn) Rollback() {
+	if t.closed {
+		return
+	}
+	t.closed = true
+	t.unlockAll()
+}
@@ -0,0 +1,212 @@
+package db
+
+import (
+	"io"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+// IOPriority orders background writes waiting on a RateLimiter.
+type IOPriority int
+
+const (
+	IOPriorityLow  IOPriority = iota // compactions
+	IOPriorityHigh                   // flushes, which free memtables
+)
+
+const (
+	// Tokens are added continuously, but at most refillPeriod worth of them
+	// accumulate while nobody writes.
+	refillPeriod = 100 * time.Millisecond
+
+	// An auto-tuned limiter runs at its maximum rate once the pending
+	// compaction debt would take autoTuneWindow to write at that rate, and
+	// slows down proportionally to the debt below that, down to
+	// 1/autoTuneMinDivisor of the maximum.
+	autoTuneWindow     = 10 * time.Second
+	autoTuneMinDivisor = 20
+)
+
+// RateLimiter is a token bucket limiting the bytes per second written by
+// flushes and compactions. Writes of high priority are served before writes
+// of low priority. A RateLimiter may be shared by several DBs.
+type RateLimiter struct {
+	mu          sync.Mutex
+	bytesPerSec int64
+	available   int64 // tokens in the bucket
+	lastRefill  time.Time
+	waitingHigh int // high priority writes waiting for tokens
+
+	autoTuned      bool
+	maxBytesPerSec int64
+
+	totalBytes [2]atomic.Int64 // bytes let through per priority
+
+	// Replaced by tests to control time.
+	now   func() time.Time
+	sleep func(time.Duration)
+}
+
+// NewRateLimiter returns a limiter allowing bytesPerSec bytes per second.
+func NewRateLimiter(bytesPerSec int64) *RateLimiter {
+	return &RateLimiter{bytesPerSec: bytesPerSec, lastRefill: time.Now(), now: time.Now, sleep: time.Sleep}
+}
+
+// NewAutoTunedRateLimiter returns a limiter whose rate follows the amount of
+// data waiting to be compacted, up to maxBytesPerSec bytes per second. It
+// starts at the maximum rate.
+func NewAutoTunedRateLimiter(maxBytesPerSec int64) *RateLimiter {
+	l := NewRateLimiter(maxBytesPerSec)
+	l.autoTuned, l.maxBytesPerSec = true, maxBytesPerSec
+	return l
+}
+
+// SetBytesPerSecond changes the rate of the limiter. For an auto-tuned
+// limiter it changes the maximum rate.
+func (l *RateLimiter) SetBytesPerSecond(bytesPerSec int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	l.refill()
+	if l.autoTuned {
+		l.maxBytesPerSec = bytesPerSec
+	}
+	l.bytesPerSec = bytesPerSec
+}
+
+// BytesPerSecond returns the current rate of the limiter.
+func (l *RateLimiter) BytesPerSecond() int64 {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	return l.bytesPerSec
+}
+
+// TotalBytesThrough returns the number of bytes let through with the given
+// priority.
+func (l *RateLimiter) TotalBytesThrough(pri IOPriority) int64 {
+	return l.totalBytes[pri].Load()
+}
+
+// Request blocks until n bytes may be written with the given priority.
+// Requests larger than the bucket are served in parts.
+func (l *RateLimiter) Request(n int, pri IOPriority) {
+	for n > 0 {
+		n -= l.acquire(n, pri)
+	}
+}
+
+// Waits for up to n tokens, at most a full bucket, and returns their number.
+func (l *RateLimiter) acquire(n int, pri IOPriority) int {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if pri == IOPriorityHigh {
+		l.waitingHigh++
+		defer func() { l.waitingHigh-- }()
+	}
+	for {
+		if l.bytesPerSec <= 0 {
+			break // unlimited
+		}
+		l.refill()
+		n = min(n, int(l.capacity()))
+		if l.available >= int64(n) && (pri == IOPriorityHigh || l.waitingHigh == 0) {
+			l.available -= int64(n)
+			break
+		}
+
+		wait := refillPeriod
+		if missing := int64(n) - l.available; missing > 0 {
+			wait = time.Duration(missing * int64(time.Second) / l.bytesPerSec)
+		}
+		l.mu.Unlock()
+		l.sleep(min(max(wait, time.Millisecond), refillPeriod))
+		l.mu.Lock()
+	}
+	l.totalBytes[pri].Add(int64(n))
+	return n
+}
+
+func (l *RateLimiter) capacity() int64 {
+	return max(l.bytesPerSec*int64(refillPeriod)/int64(time.Second), 1)
+}
+
+func (l *RateLimiter) refill() {
+	now := l.now()
+	elapsed := now.Sub(l.lastRefill)
+	l.lastRefill = now
+
+	l.available = min(l.available+l.bytesPerSec*int64(elapsed)/int64(time.Second), l.capacity())
+}
+
+// Adjusts the rate of an auto-tuned limiter to the number of bytes waiting
+// to be compacted.
+func (l *RateLimiter) tune(pendingBytes int64) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.autoTuned {
+		return
+	}
+	l.refill()
+	full := l.maxBytesPerSec * int64(autoTuneWindow/time.Second)
+	rate := l.maxBytesPerSec * min(pendingBytes, full) / max(full, 1)
+	l.bytesPerSec = max(rate, l.maxBytesPerSec/autoTuneMinDivisor)
+}
+
+// writableFile is what sstable.Writer needs from a file.
+type writableFile interface {
+	io.WriteCloser
+	Sync() error
+}
+
+// limitedFile passes writes to a file through a RateLimiter.
+type limitedFile struct {
+	f       *os.File
+	limiter *RateLimiter
+	pri     IOPriority
+}
+
+func (f *limitedFile) Write(p []byte) (int, error) {
+	f.limiter.Request(len(p), f.pri)
+	return f.f.Write(p)
+}
+
+func (f *limitedFile) Sync() error {
+	return f.f.Sync()
+}
+
+func (f *limitedFile) Close() error {
+	return f.f.Close()
+}
+
+// Opens a new sstable for writing by a background job. Writes go through
+// Options.RateLimiter, if any.
+func (d *DB) openTableForWriting(meta *storage.FileMetadata, pri IOPriority) (writableFile, error) {
+	f, err := d.dataStorage.OpenFileForWriting(meta)
+	if err != nil {
+		return nil, err
+	}
+	if d.opts.RateLimiter == nil {
+		return f, nil
+	}
+	return &limitedFile{f: f, limiter: d.opts.RateLimiter, pri: pri}, nil
+}
+
+// Feeds the compaction debt to an auto-tuned rate limiter: every byte in
+// level 0 still has to be compacted into lower levels.
+func (d *DB) tuneRateLimiter() {
+	if d.opts.RateLimiter == nil {
+		return
+	}
+	var pending int64
+	for _, t := range d.levels[0] {
+		pending += t.size
+	}
+	d.opts.RateLimiter.tune(pending)
+}
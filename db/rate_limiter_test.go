@@ -0,0 +1,146 @@
+package db
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeClock stands in for the time of a RateLimiter. Without sleeps, a sleep
+// advances the clock by its duration. Otherwise every sleep sends a channel
+// on sleeps and blocks until the test closes it.
+type fakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	sleeps chan chan struct{}
+}
+
+func (c *fakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.now
+}
+
+func (c *fakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.now = c.now.Add(d)
+}
+
+func (c *fakeClock) Sleep(d time.Duration) {
+	if c.sleeps == nil {
+		c.Advance(d)
+		return
+	}
+	wake := make(chan struct{})
+	c.sleeps <- wake
+	<-wake
+}
+
+func newTestRateLimiter(bytesPerSec int64, c *fakeClock) *RateLimiter {
+	l := NewRateLimiter(bytesPerSec)
+	l.now, l.sleep, l.lastRefill = c.Now, c.Sleep, c.Now()
+	return l
+}
+
+func TestRateLimiterThrottles(t *testing.T) {
+	c := &fakeClock{}
+	l := newTestRateLimiter(100<<10, c)
+	l.Request(30<<10, IOPriorityLow)
+	if elapsed := c.Now().Sub(time.Time{}); elapsed != 300*time.Millisecond {
+		t.Fatalf("expected 30 KiB at 100 KiB/s to take 300ms, took %s", elapsed)
+	}
+	if n := l.TotalBytesThrough(IOPriorityLow); n != 30<<10 {
+		t.Fatalf("expected 30 KiB to go through, got %d", n)
+	}
+
+	l.SetBytesPerSecond(0)
+	before := c.Now()
+	l.Request(1<<30, IOPriorityLow)
+	if elapsed := c.Now().Sub(before); elapsed != 0 {
+		t.Fatalf("expected unlimited limiter not to block, took %s", elapsed)
+	}
+}
+
+func TestRateLimiterPriority(t *testing.T) {
+	c := &fakeClock{sleeps: make(chan chan struct{})}
+	// Both requests fit in one bucket of 5 KiB.
+	l := newTestRateLimiter(50<<10, c)
+	done := make(chan IOPriority, 2)
+	go func() {
+		l.Request(5<<10, IOPriorityLow)
+		done <- IOPriorityLow
+	}()
+	wakeLow := <-c.sleeps
+	go func() {
+		l.Request(5<<10, IOPriorityHigh)
+		done <- IOPriorityHigh
+	}()
+	wakeHigh := <-c.sleeps
+
+	// Enough tokens for one request: the low priority one waits for the
+	// high priority one even though it has been waiting longer.
+	c.Advance(refillPeriod)
+	close(wakeLow)
+	wakeLow = <-c.sleeps
+	if n := l.TotalBytesThrough(IOPriorityLow); n != 0 {
+		t.Fatalf("expected the low priority request to wait, got %d bytes through", n)
+	}
+	close(wakeHigh)
+	if pri := <-done; pri != IOPriorityHigh {
+		t.Fatal("expected the high priority request to finish first")
+	}
+	if n := l.TotalBytesThrough(IOPriorityHigh); n != 5<<10 {
+		t.Fatalf("expected 5 KiB of high priority to go through, got %d", n)
+	}
+
+	c.Advance(refillPeriod)
+	close(wakeLow)
+	<-done
+	if n := l.TotalBytesThrough(IOPriorityLow); n != 5<<10 {
+		t.Fatalf("expected 5 KiB of low priority to go through, got %d", n)
+	}
+}
+
+func TestRateLimiterAutoTune(t *testing.T) {
+	l := NewAutoTunedRateLimiter(1 << 20)
+	l.tune(0)
+	if rate := l.BytesPerSecond(); rate != (1<<20)/autoTuneMinDivisor {
+		t.Fatalf("expected minimum rate without debt, got %d", rate)
+	}
+	l.tune(5 << 20)
+	if rate := l.BytesPerSecond(); rate != 1<<19 {
+		t.Fatalf("expected half the maximum rate, got %d", rate)
+	}
+	l.tune(1 << 30)
+	if rate := l.BytesPerSecond(); rate != 1<<20 {
+		t.Fatalf("expected maximum rate, got %d", rate)
+	}
+}
+
+func TestRateLimitedJobs(t *testing.T) {
+	l := NewRateLimiter(1 << 30)
+	d, err := Open(t.TempDir(), &Options{RateLimiter: l})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 1000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("val"))
+	}
+	flush, err := d.Flush().Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := l.TotalBytesThrough(IOPriorityHigh); n < flush.BytesWritten {
+		t.Fatalf("expected flushes to write %d bytes through the limiter, got %d", flush.BytesWritten, n)
+	}
+	compaction, err := d.CompactRange(nil, nil).Wait()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n := l.TotalBytesThrough(IOPriorityLow); n != compaction.BytesWritten {
+		t.Fatalf("expected compactions to write %d bytes through the limiter, got %d", compaction.BytesWritten, n)
+	}
+}
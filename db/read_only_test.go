@@ -0,0 +1,87 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"testing"
+)
+
+func listDir(t *testing.T, dir string) []string {
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, e := range entries {
+		names = append(names, e.Name())
+	}
+	return names
+}
+
+func TestReadOnly(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 100; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v"))
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	os.Remove(filepath.Join(dir, "LOCK"))
+	before := listDir(t, dir)
+
+	r, err := Open(dir, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.Close() })
+	if v, err := r.Get([]byte("key0042")); err != nil || string(v) != "v" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+
+	if err = r.Set([]byte("key"), []byte("v")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Set: expected ErrReadOnly, got %v", err)
+	}
+	if err = r.Delete([]byte("key0042")); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Delete: expected ErrReadOnly, got %v", err)
+	}
+	b := NewBatch()
+	b.Set([]byte("key"), []byte("v"))
+	if err = r.Apply(b); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Apply: expected ErrReadOnly, got %v", err)
+	}
+	if _, err = r.Flush().Wait(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("Flush: expected ErrReadOnly, got %v", err)
+	}
+	if _, err = r.CompactRange(nil, nil).Wait(); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("CompactRange: expected ErrReadOnly, got %v", err)
+	}
+	external := filepath.Join(t.TempDir(), "external.sst")
+	writeExternalFile(t, external, 0, 10, "v")
+	if err = r.IngestExternalFiles([]string{external}); !errors.Is(err, ErrReadOnly) {
+		t.Fatalf("IngestExternalFiles: expected ErrReadOnly, got %v", err)
+	}
+
+	if after := listDir(t, dir); !slices.Equal(before, after) {
+		t.Fatalf("directory changed from %v to %v", before, after)
+	}
+}
+
+func TestReadOnlyMissingDirectory(t *testing.T) {
+	dir := filepath.Join(t.TempDir(), "missing")
+	if _, err := Open(dir, &Options{ReadOnly: true}); err == nil {
+		t.Fatal("expected an error")
+	}
+	if _, err := os.Stat(dir); !os.IsNotExist(err) {
+		t.Fatalf("expected the directory not to be created, got %v", err)
+	}
+}
@@ -0,0 +1,422 @@
+package db
+
+import (
+	"cmp"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+	"github.com/wubba-com/lsm-tree/memtable"
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// Subdirectory of the data directory that Repair moves damaged files to.
+const lostDirName = "lost"
+
+// RepairAction tells what Repair did with a file.
+type RepairAction int
+
+const (
+	RepairKept     RepairAction = iota // the table is intact and was kept as it is
+	RepairSalvaged                     // the readable entries of the table were copied to a new one
+	RepairReplayed                     // the writes of the WAL were replayed into new tables
+	RepairLost                         // nothing could be read from the file
+	RepairSkipped                      // the table is not in the manifest, so not part of the DB
+)
+
+func (a RepairAction) String() string {
+	switch a {
+	case RepairKept:
+		return "kept"
+	case RepairSalvaged:
+		return "salvaged"
+	case RepairReplayed:
+		return "replayed"
+	case RepairLost:
+		return "lost"
+	default:
+		return "skipped"
+	}
+}
+
+// RepairedFile describes what Repair did with a file of the data directory.
+type RepairedFile struct {
+	FileNum int
+	Action  RepairAction
+	Entries int   // number of entries recovered from a salvaged table or a replayed WAL
+	Tables  []int // numbers of the tables written from the file
+	Err     error // first problem found in the file, if any
+}
+
+func (f RepairedFile) String() string {
+	s := fmt.Sprintf("file %d: %s", f.FileNum, f.Action)
+	if f.Action == RepairSalvaged || f.Action == RepairReplayed {
+		s += fmt.Sprintf(" %d entries into tables %v", f.Entries, f.Tables)
+	}
+	if f.Err != nil {
+		s += fmt.Sprintf(": %v", f.Err)
+	}
+	return s
+}
+
+// RepairReport is the result of Repair.
+type RepairReport struct {
+	// ManifestErr is why the manifest could not be used, if it could not.
+	// Every sstable in the data directory is then part of the repaired DB.
+	ManifestErr error
+	Files       []RepairedFile
+}
+
+func (r *RepairReport) String() string {
+	var sb strings.Builder
+	if r.ManifestErr != nil {
+		fmt.Fprintf(&sb, "manifest rebuilt from the data directory: %v\n", r.ManifestErr)
+	}
+	counts := make(map[RepairAction]int)
+	for _, f := range r.Files {
+		counts[f.Action]++
+	}
+	fmt.Fprintf(&sb, "%d files: %d kept, %d salvaged, %d replayed, %d lost, %d skipped",
+		len(r.Files), counts[RepairKept], counts[RepairSalvaged], counts[RepairReplayed], counts[RepairLost], counts[RepairSkipped])
+	for _, f := range r.Files {
+		if f.Action != RepairKept && f.Action != RepairSkipped {
+			fmt.Fprintf(&sb, "\n  %s", f)
+		}
+	}
+	return sb.String()
+}
+
+// Repair rebuilds the DB in dirname, which must not be open, from the files
+// that survive a crash or a lost manifest, and writes a new manifest.
+//
+// Intact sstables are kept as they are. The entries that can still be read
+// from damaged sstables are copied to new ones, and the WALs are replayed
+// into new level 0 tables. Damaged WALs and sstables, and missing ones,
+// are moved to the "lost" subdirectory once the new manifest is written.
+// If the manifest can be read, the tables keep their levels and tables it
+// does not reference are left out. Otherwise every sstable goes to level 0,
+// ordered by the flush number it records, which tells how fresh its data
+// is; tables that record none go below the others, ordered by file number,
+// and so do ingested tables. Tables replaced by compactions that were not
+// deleted yet come back, which may bring back keys deleted since. WALs are
+// then replayed from the newest WAL number recorded in an intact table;
+// tables that record none, such as ingested ones or tables written before
+// the number was recorded, cannot rule out any WAL, so flushed WALs that
+// were not deleted yet may bring back overwritten and deleted keys too.
+// opts may be nil to use the defaults; the comparer must be the one the DB
+// was created with.
+func Repair(dirname string, opts *Options) (*RepairReport, error) {
+	opts = opts.withDefaults()
+	if opts.ReadOnly {
+		return nil, ErrReadOnly
+	}
+	dataStorage, err := storage.NewProvider(dirname)
+	if err != nil {
+		return nil, err
+	}
+	dirLock, err := dataStorage.Lock()
+	if err != nil {
+		return nil, err
+	}
+	defer dirLock.Close()
+
+	d := &DB{opts: opts, cmp: opts.Comparer, dataStorage: dataStorage}
+	report := &RepairReport{}
+	err = d.repair(report)
+	// Let the deletion of replayed WALs finish.
+	d.bgWG.Wait()
+	if err != nil {
+		return nil, err
+	}
+	return report, nil
+}
+
+func (d *DB) repair(report *RepairReport) error {
+	var m *manifest
+	buf, err := d.dataStorage.ReadManifest()
+	if err == nil {
+		m, err = decodeManifest(buf)
+	}
+	switch {
+	case err == nil && m.comparer != d.cmp.Name():
+		return fmt.Errorf("%w: DB was created with %q, repaired with %q", sstable.ErrComparerMismatch, m.comparer, d.cmp.Name())
+	case err == nil:
+		d.logNum = m.logNum
+		d.dataStorage.MarkFileNumUsed(m.nextFileNum - 1)
+	case errors.Is(err, os.ErrNotExist), errors.Is(err, ErrCorruptedManifest):
+		report.ManifestErr = err
+	default:
+		return err
+	}
+
+	files, err := d.dataStorage.ListFiles()
+	if err != nil {
+		return err
+	}
+	slices.SortFunc(files, func(a, b *storage.FileMetadata) int {
+		return a.FileNum() - b.FileNum()
+	})
+	onDisk := make(map[int]bool)
+	for _, f := range files {
+		if f.IsSSTable() {
+			onDisk[f.FileNum()] = true
+		}
+	}
+
+	// The tables that make up the DB, from the manifest if there is one.
+	var sources [numLevels][]*storage.FileMetadata
+	if m != nil {
+		inManifest := make(map[int]bool)
+		for level, tables := range m.levels {
+			for _, t := range tables {
+				inManifest[t.FileNum()] = true
+				sources[level] = append(sources[level], t.FileMetadata)
+			}
+		}
+		for _, f := range files {
+			if f.IsSSTable() && !inManifest[f.FileNum()] {
+				report.Files = append(report.Files, RepairedFile{FileNum: f.FileNum(), Action: RepairSkipped})
+			}
+		}
+	} else {
+		for _, f := range files {
+			if f.IsSSTable() {
+				sources[0] = append(sources[0], f)
+			}
+		}
+	}
+
+	// Damaged files are only moved away once the new manifest no longer
+	// references them.
+	var lost []*storage.FileMetadata
+	flushNums := make(map[*tableMeta]int)
+	for level, metas := range sources {
+		for _, meta := range metas {
+			if !onDisk[meta.FileNum()] {
+				report.Files = append(report.Files, RepairedFile{FileNum: meta.FileNum(), Action: RepairLost, Err: os.ErrNotExist})
+				continue
+			}
+			t, flushNum, rf, err := d.repairTable(meta, level)
+			if err != nil {
+				return err
+			}
+			report.Files = append(report.Files, rf)
+			if t != nil {
+				d.levels[level] = append(d.levels[level], t)
+				flushNums[t] = flushNum
+			}
+			if rf.Action != RepairKept {
+				lost = append(lost, meta)
+			}
+		}
+	}
+	if m == nil {
+		// Compactions give their outputs numbers larger than those of tables
+		// flushed meanwhile, so level 0 is ordered by the flush numbers the
+		// tables record instead. Tables that record none go below the others.
+		slices.SortStableFunc(d.levels[0], func(a, b *tableMeta) int {
+			return cmp.Compare(flushNums[a], flushNums[b])
+		})
+	}
+	// Intact tables may have raised d.logNum, which tells the WALs that only
+	// hold flushed writes when the manifest is lost.
+	for _, meta := range files {
+		if !meta.IsWAL() || meta.FileNum() < d.logNum {
+			continue
+		}
+		tables, rf, err := d.replayWALForRepair(meta)
+		if err != nil {
+			return err
+		}
+		report.Files = append(report.Files, rf)
+		d.levels[0] = append(d.levels[0], tables...)
+		if rf.Err != nil {
+			lost = append(lost, meta)
+		}
+	}
+
+	// Every WAL left has been replayed, so the DB starts with a new one.
+	d.logNum = d.dataStorage.LastFileNum() + 1
+	err = d.writeManifest()
+	if err != nil {
+		return err
+	}
+	for _, meta := range lost {
+		err = d.moveToLost(meta)
+		if err != nil {
+			return err
+		}
+	}
+	err = d.dataStorage.SyncDir()
+	if err != nil {
+		return err
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.deleteObsoleteWALs()
+	return nil
+}
+
+// Keeps the table meta if it is intact, and otherwise copies its readable
+// entries to a new table for the given level. Returns the table to use
+// instead, if any, and an error only if the new table cannot be written.
+func (d *DB) repairTable(meta *storage.FileMetadata, level int) (*tableMeta, int, RepairedFile, error) {
+	rf := RepairedFile{FileNum: meta.FileNum(), Action: RepairLost}
+	f, err := d.dataStorage.OpenFileForReading(meta)
+	if err != nil {
+		rf.Err = err
+		return nil, 0, rf, nil
+	}
+	r, err := sstable.NewReader(f, d.cmp)
+	if err != nil {
+		f.Close()
+		rf.Err = err
+		return nil, 0, rf, nil
+	}
+	defer r.Close()
+	flushNum, _ := r.FlushNum()
+
+	errs := r.Verify()
+	if len(errs) == 0 {
+		t, err := d.loadTableMeta(meta)
+		if err == nil {
+			rf.Action = RepairKept
+			// WALs below the log number of the table hold only writes found
+			// in it or in older tables.
+			if logNum, ok := r.LogNum(); ok {
+				d.logNum = max(d.logNum, logNum)
+			}
+			return t, flushNum, rf, nil
+		}
+		errs = append(errs, err)
+	}
+	rf.Err = errs[0]
+
+	t, n, err := d.writeRepairedTable(level, func(w *sstable.Writer) (int, error) {
+		// The salvaged entries are as fresh as the damaged table.
+		w.SetFlushNum(flushNum)
+		var n int
+		_, err := r.Salvage(func(key, val []byte) error {
+			if len(val) == 0 {
+				return nil // Not an encoded value.
+			}
+			n++
+			return w.Add(key, val)
+		})
+		return n, err
+	})
+	if err != nil || t == nil {
+		return nil, 0, rf, err
+	}
+	rf.Action = RepairSalvaged
+	rf.Entries = n
+	rf.Tables = []int{t.FileNum()}
+	return t, flushNum, rf, nil
+}
+
+// Replays the writes of a WAL into new level 0 tables of about
+// Options.TargetFileSize bytes, ordered from oldest to newest.
+func (d *DB) replayWALForRepair(meta *storage.FileMetadata) ([]*tableMeta, RepairedFile, error) {
+	rf := RepairedFile{FileNum: meta.FileNum(), Action: RepairLost}
+	buf, err := os.ReadFile(d.dataStorage.Path(meta))
+	if err != nil {
+		rf.Err = err
+		return nil, rf, nil
+	}
+	batches, off := decodeWALRecords(buf)
+	if off < len(buf) {
+		rf.Err = fmt.Errorf("%w: %d bytes at offset %d cannot be read", errCorruptedBatch, len(buf)-off, off)
+	}
+	rf.Action = RepairReplayed
+
+	var tables []*tableMeta
+	m := memtable.NewMemtable(d.opts.TargetFileSize, d.cmp)
+	flush := func() error {
+		t, _, err := d.writeRepairedTable(0, func(w *sstable.Writer) (int, error) {
+			return d.writeFiltered(w, m.Iterator(), 0, false)
+		})
+		if t != nil {
+			tables = append(tables, t)
+			rf.Tables = append(rf.Tables, t.FileNum())
+		}
+		m = memtable.NewMemtable(d.opts.TargetFileSize, d.cmp)
+		return err
+	}
+	for _, b := range batches {
+		for _, op := range b.ops {
+			if !m.HasRoomForWrite(op.key, op.val) {
+				if err = flush(); err != nil {
+					return nil, rf, err
+				}
+			}
+			if op.kind == encoder.OpKindDelete {
+				m.InsertTombstone(op.key, 0)
+			} else {
+				m.Insert(op.key, op.val, 0)
+			}
+			rf.Entries++
+		}
+	}
+	if m.Size() > 0 {
+		if err = flush(); err != nil {
+			return nil, rf, err
+		}
+	}
+	return tables, rf, nil
+}
+
+// Writes a new table for the given level with write, which returns the
+// number of entries it added. Returns nil if there were none, in which case
+// no table is left behind. The table records its own number as its flush
+// number, like a flushed table, unless write sets another one.
+func (d *DB) writeRepairedTable(level int, write func(w *sstable.Writer) (int, error)) (*tableMeta, int, error) {
+	meta := d.dataStorage.PrepareNewFile()
+	f, err := d.dataStorage.OpenFileForWriting(meta)
+	if err != nil {
+		return nil, 0, err
+	}
+	w := sstable.NewWriter(f, d.cmp)
+	w.SetFlushNum(meta.FileNum())
+	n, err := write(w)
+	if err != nil {
+		w.Close()
+		d.deleteTable(meta)
+		return nil, 0, err
+	}
+	err = w.Close()
+	if err != nil {
+		d.deleteTable(meta)
+		return nil, 0, err
+	}
+	if n == 0 {
+		d.deleteTable(meta)
+		return nil, 0, nil
+	}
+
+	t, err := d.loadTableMeta(meta)
+	if err != nil {
+		d.deleteTable(meta)
+		return nil, 0, err
+	}
+	d.opts.EventListener.tableCreated(newTableInfo(t, level))
+	return t, n, nil
+}
+
+// Moves a file out of the data directory into its lost subdirectory.
+func (d *DB) moveToLost(meta *storage.FileMetadata) error {
+	path := d.dataStorage.Path(meta)
+	if _, err := os.Stat(path); errors.Is(err, os.ErrNotExist) {
+		return nil
+	}
+	lostDir := filepath.Join(d.dataStorage.Dir(), lostDirName)
+	err := os.MkdirAll(lostDir, 0755)
+	if err != nil {
+		return err
+	}
+	return os.Rename(path, filepath.Join(lostDir, filepath.Base(path)))
+}
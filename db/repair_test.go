@@ -0,0 +1,246 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestRepairWithoutManifest(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%05d", i)))
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// A damaged table, and writes only in the WAL.
+	damaged := d.levels[numLevels-1][0]
+	d.Set([]byte("key00000"), []byte("unflushed"))
+	d.Delete([]byte("key02999"))
+	crash(d)
+
+	corruptTable(t, d.dataStorage.Path(damaged.FileMetadata))
+	if err = os.WriteFile(filepath.Join(dir, "999999.sst"), []byte("not an sstable"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "MANIFEST")); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := Repair(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if report.ManifestErr == nil {
+		t.Fatal("expected the missing manifest to be reported")
+	}
+	actions := make(map[int]RepairAction)
+	for _, f := range report.Files {
+		actions[f.FileNum] = f.Action
+	}
+	if actions[damaged.FileNum()] != RepairSalvaged || actions[999999] != RepairLost {
+		t.Fatalf("expected table %d to be salvaged and table 999999 lost, got\n%s", damaged.FileNum(), report)
+	}
+	for _, name := range []string{filepath.Base(d.dataStorage.Path(damaged.FileMetadata)), "999999.sst"} {
+		if _, err = os.Stat(filepath.Join(dir, lostDirName, name)); err != nil {
+			t.Fatalf("expected %s to be moved to the lost directory: %v", name, err)
+		}
+	}
+
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if v, err := d.Get([]byte("key00000")); err != nil || string(v) != "unflushed" {
+		t.Fatalf("expected the WAL to be replayed, got %q, %v", v, err)
+	}
+	if _, err = d.Get([]byte("key02999")); err != ErrNotFound {
+		t.Fatalf("expected key02999 to stay deleted, got %v", err)
+	}
+	if v, err := d.Get([]byte("key02000")); err != nil || string(v) != "value02000" {
+		t.Fatalf("expected %q, got %q, %v", "value02000", v, err)
+	}
+	verify, err := d.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !verify.OK() {
+		t.Fatalf("expected the repaired DB to verify, got %s", verify)
+	}
+}
+
+func TestRepairSkipsFlushedWALs(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("key"), []byte("old"))
+	wal := walFiles(t, dir)[0]
+	stale, err := os.ReadFile(filepath.Join(dir, wal))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("key"), []byte("new"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	waitForBackgroundWork(d)
+	crash(d)
+
+	// A flushed WAL that was not deleted before the crash.
+	if err = os.WriteFile(filepath.Join(dir, wal), stale, 0644); err != nil {
+		t.Fatal(err)
+	}
+	if err = os.Remove(filepath.Join(dir, "MANIFEST")); err != nil {
+		t.Fatal(err)
+	}
+	report, err := Repair(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, f := range report.Files {
+		if f.Action == RepairReplayed && len(f.Tables) > 0 {
+			t.Fatalf("expected the flushed WAL to be skipped, got\n%s", report)
+		}
+	}
+
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if v, err := d.Get([]byte("key")); err != nil || string(v) != "new" {
+		t.Fatalf("expected %q, got %q, %v", "new", v, err)
+	}
+}
+
+func TestRepairOrdersLevel0(t *testing.T) {
+	filter := &gatedFilter{blocked: make(chan struct{}), release: make(chan struct{})}
+	dir := t.TempDir()
+	d, err := Open(dir, &Options{CompactionFilter: filter, TargetFileSize: 4 << 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 1000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("old"))
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	// A flush finishes while the compaction runs, whose later outputs get
+	// larger numbers than the flushed table.
+	job := d.CompactRange(nil, nil)
+	<-filter.blocked
+	d.Set([]byte("key00999"), []byte("new"))
+	d.Delete([]byte("key00998"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	close(filter.release)
+	if _, err = job.Wait(); err != nil {
+		t.Fatal(err)
+	}
+	flushed := d.levels[0][0]
+	if last := d.levels[numLevels-1][len(d.levels[numLevels-1])-1]; last.FileNum() < flushed.FileNum() {
+		t.Fatalf("expected the last compaction output %d to be newer than table %d", last.FileNum(), flushed.FileNum())
+	}
+	waitForBackgroundWork(d)
+	crash(d)
+
+	if err = os.Remove(filepath.Join(dir, "MANIFEST")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = Repair(dir, nil); err != nil {
+		t.Fatal(err)
+	}
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if v, err := d.Get([]byte("key00999")); err != nil || string(v) != "new" {
+		t.Fatalf("expected %q, got %q, %v", "new", v, err)
+	}
+	if _, err = d.Get([]byte("key00998")); err != ErrNotFound {
+		t.Fatalf("expected key00998 to stay deleted, got %v", err)
+	}
+	if v, err := d.Get([]byte("key00000")); err != nil || string(v) != "old" {
+		t.Fatalf("expected %q, got %q, %v", "old", v, err)
+	}
+}
+
+func TestRepairKeepsLevels(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 3000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("v"))
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	bottom := d.levels[numLevels-1]
+	if err = d.Close(); err != nil {
+		t.Fatal(err)
+	}
+	corruptTable(t, d.dataStorage.Path(bottom[0].FileMetadata))
+	writeExternalFile(t, filepath.Join(dir, "999999.sst"), 0, 10, "stale")
+
+	report, err := Repair(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var kept, salvaged, skipped int
+	for _, f := range report.Files {
+		switch f.Action {
+		case RepairKept:
+			kept++
+		case RepairSalvaged:
+			salvaged++
+		case RepairSkipped:
+			skipped++
+		}
+	}
+	// A table missing from the manifest is not part of the DB.
+	if kept != len(bottom)-1 || salvaged != 1 || skipped != 1 {
+		t.Fatalf("expected %d tables kept, 1 salvaged and 1 skipped, got\n%s", len(bottom)-1, report)
+	}
+
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if len(d.levels[0]) != 0 || len(d.levels[numLevels-1]) != len(bottom) {
+		t.Fatalf("expected the %d tables to stay in the bottom level, got %d in L0 and %d at the bottom", len(bottom), len(d.levels[0]), len(d.levels[numLevels-1]))
+	}
+	if v, err := d.Get([]byte("key02999")); err != nil || string(v) != "v" {
+		t.Fatalf("expected %q, got %q, %v", "v", v, err)
+	}
+}
+
+func TestRepairLockedDB(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	if _, err = Repair(dir, nil); err != ErrLocked {
+		t.Fatalf("expected ErrLocked, got %v", err)
+	}
+}
@@ -0,0 +1,10 @@
+//go:build !(darwin || dragonfly || freebsd || linux || netbsd || openbsd)
+
+package storage
+
+import "os"
+
+// Platforms without flock are not protected from concurrent opens.
+func lockFile(f *os.File) error {
+	return nil
+}
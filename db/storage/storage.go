@@ -1,14 +1,28 @@
 package storage
 
 import (
+	"errors"
 	"fmt"
+	"hash/crc32"
+	"io"
 	"os"
 	"path/filepath"
 )
 
+const (
+	manifestFileName = "MANIFEST"
+	lockFileName     = "LOCK"
+)
+
+var (
+	ErrLocked   = errors.New("data directory is locked by another process")
+	ErrReadOnly = errors.New("data directory is opened read-only")
+)
+
 type Provider struct {
-	dataDir string
-	fileNum int
+	dataDir  string
+	fileNum  int
+	readOnly bool
 }
 
 type FileType int
@@ -16,8 +30,15 @@ type FileType int
 const (
 	FileTypeUnknown FileType = iota
 	FileTypeSSTable
+	FileTypeWAL
 )
 
+// Extensions of the files of each type.
+var fileExtensions = map[FileType]string{
+	FileTypeSSTable: "sst",
+	FileTypeWAL:     "log",
+}
+
 type FileMetadata struct {
 	fileNum  int
 	fileType FileType
@@ -27,10 +48,18 @@ func (f *FileMetadata) IsSSTable() bool {
 	return f.fileType == FileTypeSSTable
 }
 
+func (f *FileMetadata) IsWAL() bool {
+	return f.fileType == FileTypeWAL
+}
+
 func (f *FileMetadata) FileNum() int {
 	return f.fileNum
 }
 
+func NewFileMetadata(fileNum int, fileType FileType) *FileMetadata {
+	return &FileMetadata{fileNum: fileNum, fileType: fileType}
+}
+
 func NewProvider(dataDir string) (*Provider, error) {
 	s := &Provider{dataDir: dataDir}
 
@@ -38,8 +67,43 @@ func NewProvider(dataDir string) (*Provider, error) {
 	if err != nil {
 		return nil, err
 	}
+	return s, s.scanFileNums()
+}
+
+// NewReadOnlyProvider returns a Provider for an existing data directory that
+// refuses to create, rename or delete files in it.
+func NewReadOnlyProvider(dataDir string) (*Provider, error) {
+	s := &Provider{dataDir: dataDir, readOnly: true}
+
+	info, err := os.Stat(dataDir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dataDir)
+	}
+	return s, s.scanFileNums()
+}
+
+func (s *Provider) scanFileNums() error {
+	// Never reuse the number of a file that is already on disk.
+	meta, err := s.ListFiles()
+	if err != nil {
+		return err
+	}
+	for _, f := range meta {
+		s.MarkFileNumUsed(f.fileNum)
+	}
+	return nil
+}
 
-	return s, nil
+// ReadOnly reports whether the Provider refuses to modify the data directory.
+func (s *Provider) ReadOnly() bool {
+	return s.readOnly
+}
+
+func (s *Provider) Dir() string {
+	return s.dataDir
 }
 
 func (s *Provider) ensureDataDirExists() error {
@@ -61,11 +125,13 @@ func (s *Provider) ListFiles() ([]*FileMetadata, error) {
 	for _, f := range files {
 		_, err = fmt.Sscanf(f.Name(), "%06d.%s", &fileNumber, &fileExtension)
 		if err != nil {
-			return nil, err
+			continue // Not a numbered data file, e.g. the MANIFEST.
 		}
 		fileType := FileTypeUnknown
-		if fileExtension == "sst" {
-			fileType = FileTypeSSTable
+		for t, ext := range fileExtensions {
+			if fileExtension == ext {
+				fileType = t
+			}
 		}
 		meta = append(meta, &FileMetadata{
 			fileNum:  fileNumber,
@@ -80,8 +146,25 @@ func (s *Provider) nextFileNum() int {
 	return s.fileNum
 }
 
-func (s *Provider) generateFileName(fileNumber int) string {
-	return fmt.Sprintf("%06d.sst", fileNumber)
+// MarkFileNumUsed makes sure that files prepared later get numbers greater
+// than fileNum.
+func (s *Provider) MarkFileNumUsed(fileNum int) {
+	if fileNum > s.fileNum {
+		s.fileNum = fileNum
+	}
+}
+
+// LastFileNum returns the largest file number handed out so far.
+func (s *Provider) LastFileNum() int {
+	return s.fileNum
+}
+
+func (s *Provider) generateFileName(meta *FileMetadata) string {
+	ext, ok := fileExtensions[meta.fileType]
+	if !ok {
+		ext = fileExtensions[FileTypeSSTable]
+	}
+	return fmt.Sprintf("%06d.%s", meta.fileNum, ext)
 }
 
 func (s *Provider) PrepareNewFile() *FileMetadata {
@@ -91,9 +174,20 @@ func (s *Provider) PrepareNewFile() *FileMetadata {
 	}
 }
 
+// PrepareNewWAL returns the metadata of a new write-ahead log.
+func (s *Provider) PrepareNewWAL() *FileMetadata {
+	return &FileMetadata{
+		fileNum:  s.nextFileNum(),
+		fileType: FileTypeWAL,
+	}
+}
+
 func (s *Provider) OpenFileForWriting(meta *FileMetadata) (*os.File, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
 	const openFlags = os.O_RDWR | os.O_CREATE | os.O_EXCL
-	filename := s.generateFileName(meta.fileNum)
+	filename := s.generateFileName(meta)
 	file, err := os.OpenFile(filepath.Join(s.dataDir, filename), openFlags, 0644)
 	if err != nil {
 		return nil, err
@@ -103,10 +197,140 @@ func (s *Provider) OpenFileForWriting(meta *FileMetadata) (*os.File, error) {
 
 func (s *Provider) OpenFileForReading(meta *FileMetadata) (*os.File, error) {
 	const openFlags = os.O_RDONLY
-	filename := s.generateFileName(meta.fileNum)
+	filename := s.generateFileName(meta)
 	file, err := os.OpenFile(filepath.Join(s.dataDir, filename), openFlags, 0)
 	if err != nil {
 		return nil, err
 	}
 	return file, nil
 }
+
+// Path returns the location of the file on disk.
+func (s *Provider) Path(meta *FileMetadata) string {
+	return filepath.Join(s.dataDir, s.generateFileName(meta))
+}
+
+// LinkOrCopyFile makes the file at src available in the data directory
+// under the name of meta. It creates a hard link and falls back to copying
+// when linking is not possible, e.g. across file systems.
+func (s *Provider) LinkOrCopyFile(src string, meta *FileMetadata) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	dst := s.Path(meta)
+	err := os.Link(src, dst)
+	if err == nil {
+		return nil
+	}
+	_, _, err = CopyFile(src, dst)
+	return err
+}
+
+// CopyFile copies the file at src into the data directory under the name
+// of meta.
+func (s *Provider) CopyFile(src string, meta *FileMetadata) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	_, _, err := CopyFile(src, s.Path(meta))
+	return err
+}
+
+// CopyFile copies src to dst, which must not exist, and syncs the copy.
+// It returns the size and the CRC-32 (IEEE) checksum of the copied data.
+// Nothing is left at dst on failure.
+func CopyFile(src, dst string) (int64, uint32, error) {
+	in, err := os.Open(src)
+	if err != nil {
+		return 0, 0, err
+	}
+	defer in.Close()
+
+	const openFlags = os.O_WRONLY | os.O_CREATE | os.O_EXCL
+	out, err := os.OpenFile(dst, openFlags, 0644)
+	if err != nil {
+		return 0, 0, err
+	}
+	h := crc32.NewIEEE()
+	size, err := io.Copy(io.MultiWriter(out, h), in)
+	if err == nil {
+		err = out.Sync()
+	}
+	if closeErr := out.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(dst)
+		return 0, 0, err
+	}
+	return size, h.Sum32(), nil
+}
+
+func (s *Provider) ReadManifest() ([]byte, error) {
+	return os.ReadFile(filepath.Join(s.dataDir, manifestFileName))
+}
+
+// WriteManifest atomically replaces the MANIFEST with data: the new
+// contents are written to a temporary file which is then renamed.
+func (s *Provider) WriteManifest(data []byte) error {
+	if s.readOnly {
+		return ErrReadOnly
+	}
+	filename := filepath.Join(s.dataDir, manifestFileName)
+	tmp := filename + ".tmp"
+
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	_, err = f.Write(data)
+	if err == nil {
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(tmp)
+		return err
+	}
+
+	err = os.Rename(tmp, filename)
+	if err != nil {
+		return err
+	}
+	return s.SyncDir()
+}
+
+// SyncDir persists the directory entries, e.g. after a file has been
+// created or renamed.
+func (s *Provider) SyncDir() error {
+	dir, err := os.Open(s.dataDir)
+	if err != nil {
+		return err
+	}
+	err = dir.Sync()
+	if closeErr := dir.Close(); err == nil {
+		err = closeErr
+	}
+	return err
+}
+
+// Lock takes an exclusive lock on the data directory, which is held until
+// the returned file is closed. It fails with ErrLocked if the directory is
+// already locked, even by the same process.
+func (s *Provider) Lock() (io.Closer, error) {
+	if s.readOnly {
+		return nil, ErrReadOnly
+	}
+	f, err := os.OpenFile(filepath.Join(s.dataDir, lockFileName), os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	err = lockFile(f)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	return f, nil
+}
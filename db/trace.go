@@ -0,0 +1,96 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// GetTrace describes how GetWithTrace looked up a key.
+type GetTrace struct {
+	Steps    []TraceStep
+	Duration time.Duration
+}
+
+// TraceStepKind tells memtables and sstables apart in a GetTrace.
+type TraceStepKind int
+
+const (
+	TraceMemtable TraceStepKind = iota
+	TraceTable
+)
+
+// TraceOutcome is the result of searching a memtable or an sstable.
+type TraceOutcome int
+
+const (
+	TraceNotFound TraceOutcome = iota
+	TraceFound
+	TraceDeleted   // the key was found deleted
+	TraceRuledOut  // the key range of the table excludes the key; nothing was read
+	TraceReadError // reading the table failed
+)
+
+func (o TraceOutcome) String() string {
+	switch o {
+	case TraceFound:
+		return "found"
+	case TraceDeleted:
+		return "deleted"
+	case TraceRuledOut:
+		return "ruled out by key range"
+	case TraceReadError:
+		return "read error"
+	}
+	return "not found"
+}
+
+// TraceStep is a memtable or sstable searched by GetWithTrace.
+type TraceStep struct {
+	Kind     TraceStepKind
+	Memtable int // position of the memtable, counted from the newest one
+	FileNum  int // sstable only
+	Level    int // sstable only
+	Outcome  TraceOutcome
+	sstable.ReadStats
+	Duration time.Duration
+}
+
+func (s TraceStep) String() string {
+	if s.Kind == TraceMemtable {
+		return fmt.Sprintf("memtable %d: %s (%s)", s.Memtable, s.Outcome, s.Duration)
+	}
+	if s.Outcome == TraceRuledOut {
+		return fmt.Sprintf("table %d (L%d): %s", s.FileNum, s.Level, s.Outcome)
+	}
+	return fmt.Sprintf("table %d (L%d): %s, read %d index and %d data blocks, %s (%s)",
+		s.FileNum, s.Level, s.Outcome, s.IndexBlocksRead, s.DataBlocksRead, formatBytes(s.BytesRead), s.Duration)
+}
+
+func (t *GetTrace) String() string {
+	var b strings.Builder
+	for _, s := range t.Steps {
+		fmt.Fprintln(&b, s)
+	}
+	fmt.Fprintf(&b, "total: %s\n", t.Duration)
+	return b.String()
+}
+
+// Records a step. Safe to call on a nil trace, which stands for Get.
+func (t *GetTrace) add(s TraceStep) {
+	if t == nil {
+		return
+	}
+	t.Steps = append(t.Steps, s)
+}
+
+// GetWithTrace is like Get, and also returns which memtables and sstables
+// were searched, with what outcome and at what cost.
+func (d *DB) GetWithTrace(key []byte) ([]byte, *GetTrace, error) {
+	trace := &GetTrace{}
+	val, err := d.get(context.Background(), key, trace)
+	return val, trace, err
+}
@@ -0,0 +1,51 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+)
+
+func TestGetWithTrace(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for _, prefix := range []string{"a", "b"} {
+		for i := 0; i < 100; i++ {
+			d.Set([]byte(fmt.Sprintf("%s%03d", prefix, i)), []byte("v"))
+		}
+		if _, err = d.Flush().Wait(); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Set([]byte("c"), []byte("v"))
+
+	val, trace, err := d.GetWithTrace([]byte("a050"))
+	if err != nil || string(val) != "v" {
+		t.Fatalf("unexpected value %q, %v", val, err)
+	}
+	if len(trace.Steps) != 3 {
+		t.Fatalf("expected 3 steps, got:\n%s", trace)
+	}
+	mem, ruledOut, found := trace.Steps[0], trace.Steps[1], trace.Steps[2]
+	if mem.Kind != TraceMemtable || mem.Outcome != TraceNotFound {
+		t.Fatalf("expected a miss in the memtable, got %s", mem)
+	}
+	// The newer table only holds keys with the "b" prefix.
+	if ruledOut.Kind != TraceTable || ruledOut.Outcome != TraceRuledOut || ruledOut.BytesRead != 0 {
+		t.Fatalf("expected the newer table to be ruled out, got %s", ruledOut)
+	}
+	if found.Outcome != TraceFound || found.Level != 0 || found.IndexBlocksRead != 1 || found.DataBlocksRead != 1 || found.BytesRead == 0 {
+		t.Fatalf("expected the key to be read from the older table, got %s", found)
+	}
+	if s := trace.String(); !strings.Contains(s, "ruled out by key range") || !strings.Contains(s, "total: ") {
+		t.Fatalf("unexpected String output:\n%s", s)
+	}
+
+	d.Delete([]byte("c"))
+	if _, trace, err = d.GetWithTrace([]byte("c")); err != ErrNotFound || trace.Steps[0].Outcome != TraceDeleted {
+		t.Fatalf("expected the key to be found deleted in the memtable, got %v:\n%s", err, trace)
+	}
+}
@@ -0,0 +1,161 @@
+package db
+
+import (
+	"context"
+	"errors"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/memtable/encoder"
+	"github.com/wubba-com/lsm-tree/skiplist"
+)
+
+var (
+	ErrConflict  = errors.New("transaction conflict")
+	ErrTxnClosed = errors.New("transaction has already been committed or rolled back")
+)
+
+// writeBuffer keeps the uncommitted writes of a transaction sorted by key,
+// so that they can be read back and iterated over before commit.
+type writeBuffer struct {
+	sl      *skiplist.SkipList
+	encoder *encoder.Encoder
+}
+
+func newWriteBuffer(cmp comparer.Comparer) *writeBuffer {
+	return &writeBuffer{sl: skiplist.NewSkipListWithComparer(cmp)}
+}
+
+func (wb *writeBuffer) set(key, val []byte) {
+	wb.sl.Insert(key, wb.encoder.Encode(encoder.OpKindSet, val))
+}
+
+func (wb *writeBuffer) delete(key []byte) {
+	wb.sl.Insert(key, wb.encoder.Encode(encoder.OpKindDelete, nil))
+}
+
+func (wb *writeBuffer) get(key []byte) (*encoder.EncodedValue, bool) {
+	v, err := wb.sl.Find(key)
+	if err != nil {
+		return nil, false
+	}
+	return wb.encoder.Parse(v), true
+}
+
+func (wb *writeBuffer) batch() *Batch {
+	b := NewBatch()
+	i := wb.sl.Iterator()
+	for i.HasNext() {
+		key, val := i.Next()
+		encodedValue := wb.encoder.Parse(val)
+		if encodedValue.IsTombstone() {
+			b.Delete(key)
+		} else {
+			b.Set(key, encodedValue.Value())
+		}
+	}
+	return b
+}
+
+// Reads key through the write buffer: uncommitted writes shadow the DB.
+func (wb *writeBuffer) read(d *DB, key []byte) ([]byte, error) {
+	if encodedValue, ok := wb.get(key); ok {
+		if encodedValue.IsTombstone() {
+			return nil, ErrNotFound
+		}
+		return encodedValue.Value(), nil
+	}
+	return d.Get(key)
+}
+
+// Txn is an optimistic transaction. Writes are buffered until Commit, which
+// fails with ErrConflict if any key read or written by the transaction has
+// been modified by someone else since the transaction began.
+type Txn struct {
+	db       *DB
+	snapshot uint64 // sequence number of the latest write visible at begin
+	writes   *writeBuffer
+	reads    map[string]struct{}
+	closed   bool
+}
+
+func (d *DB) BeginTxn() *Txn {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	return &Txn{
+		db:       d,
+		snapshot: d.seqNum,
+		writes:   newWriteBuffer(d.cmp),
+		reads:    make(map[string]struct{}),
+	}
+}
+
+func (t *Txn) Get(key []byte) ([]byte, error) {
+	if t.closed {
+		return nil, ErrTxnClosed
+	}
+	t.reads[string(key)] = struct{}{}
+
+	return t.writes.read(t.db, key)
+}
+
+func (t *Txn) Set(key, val []byte) error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.writes.set(key, val)
+	return nil
+}
+
+func (t *Txn) Delete(key []byte) error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.writes.delete(key)
+	return nil
+}
+
+// NewIterator returns an iterator over the DB that also sees the writes made
+// by the transaction so far. Keys returned by the iterator count as read.
+func (t *Txn) NewIterator() (*Iterator, error) {
+	if t.closed {
+		return nil, ErrTxnClosed
+	}
+	it, err := t.db.newIterator(context.Background(), newSliceIterator(t.writes.sl.Iterator()))
+	if err != nil {
+		return nil, err
+	}
+	it.reads = t.reads
+
+	return it, nil
+}
+
+func (t *Txn) Commit() error {
+	if t.closed {
+		return ErrTxnClosed
+	}
+	t.closed = true
+
+	d := t.db
+	b := t.writes.batch()
+	// The leader of the group commit checks for conflicts after waiting for
+	// write stalls, which release the lock.
+	return d.write(&writer{ctx: context.Background(), batch: b, check: func() error {
+		for key := range t.reads {
+			if d.modifiedSince([]byte(key), t.snapshot) {
+				return ErrConflict
+			}
+		}
+		for _, op := range b.ops {
+			if d.modifiedSince(op.key, t.snapshot) {
+				return ErrConflict
+			}
+		}
+		return nil
+	}})
+}
+
+// Rollback discards all writes of the transaction.
+func (t *Txn) Rollback() {
+	t.closed = true
+}
@@ -0,0 +1,208 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestTxnReadsOwnWrites(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("a"), []byte("1"))
+	d.Set([]byte("c"), []byte("3"))
+
+	txn := d.BeginTxn()
+	txn.Set([]byte("b"), []byte("2"))
+	txn.Delete([]byte("c"))
+
+	v, err := txn.Get([]byte("b"))
+	if err != nil || string(v) != "2" {
+		t.Fatalf("expected uncommitted value, got %q, %v", v, err)
+	}
+	if _, err = txn.Get([]byte("c")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("expected deleted key to be missing, got %v", err)
+	}
+	if _, err = d.Get([]byte("b")); !errors.Is(err, ErrNotFound) {
+		t.Fatalf("uncommitted write is visible outside the transaction")
+	}
+
+	it, err := txn.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got string
+	for it.HasNext() {
+		key, val := it.Next()
+		got += fmt.Sprintf("%s=%s ", key, val)
+	}
+	it.Close()
+	if got != "a=1 b=2 " {
+		t.Fatalf("unexpected iteration result %q", got)
+	}
+
+	if err = txn.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if v, err = d.Get([]byte("b")); err != nil || string(v) != "2" {
+		t.Fatalf("expected committed value, got %q, %v", v, err)
+	}
+}
+
+func TestTxnConflict(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("counter"), []byte("1"))
+
+	t1 := d.BeginTxn()
+	t2 := d.BeginTxn()
+	if _, err = t1.Get([]byte("counter")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = t2.Get([]byte("counter")); err != nil {
+		t.Fatal(err)
+	}
+	t1.Set([]byte("counter"), []byte("2"))
+	t2.Set([]byte("counter"), []byte("3"))
+
+	if err = t1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	if err = t2.Commit(); !errors.Is(err, ErrConflict) {
+		t.Fatalf("expected ErrConflict, got %v", err)
+	}
+
+	// A write to an unrelated key does not conflict.
+	t3 := d.BeginTxn()
+	t3.Set([]byte("other"), []byte("x"))
+	d.Set([]byte("counter"), []byte("4"))
+	if err = t3.Commit(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestIteratorAcrossSSTables(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	const n = 2000
+	for i := 0; i < n; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("val%05d", i)))
+	}
+	for i := 0; i < n; i += 2 {
+		d.Delete([]byte(fmt.Sprintf("key%05d", i)))
+	}
+	waitForBackgroundWork(d)
+	if len(allTables(&d.levels)) == 0 {
+		t.Fatal("expected memtables to be flushed")
+	}
+
+	v, err := d.Get([]byte("key00001"))
+	if err != nil || string(v) != "val00001" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+
+	it, err := d.NewIterator()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer it.Close()
+	i := 1
+	for it.HasNext() {
+		key, _ := it.Next()
+		if want := fmt.Sprintf("key%05d", i); string(key) != want {
+			t.Fatalf("expected %s, got %s", want, key)
+		}
+		i += 2
+	}
+	if err = it.Err(); err != nil {
+		t.Fatal(err)
+	}
+	if i != n+1 {
+		t.Fatalf("iterated over %d keys, expected %d", (i-1)/2, n/2)
+	}
+}
+
+func TestPessimisticTxnLocks(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	t1 := d.BeginPessimisticTxn(nil)
+	t2 := d.BeginPessimisticTxn(&PessimisticTxnOptions{LockTimeout: 10 * time.Millisecond})
+	if err = t1.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if _, err = t2.GetForUpdate([]byte("a")); !errors.Is(err, ErrLockTimeout) {
+		t.Fatalf("expected ErrLockTimeout, got %v", err)
+	}
+	if err = t1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The lock is released on commit.
+	v, err := t2.GetForUpdate([]byte("a"))
+	if err != nil || string(v) != "1" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+	t2.Rollback()
+}
+
+func TestPessimisticTxnDeadlock(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	t1 := d.BeginPessimisticTxn(&PessimisticTxnOptions{LockTimeout: -1})
+	t2 := d.BeginPessimisticTxn(&PessimisticTxnOptions{LockTimeout: -1})
+	if err = t1.Set([]byte("a"), []byte("1")); err != nil {
+		t.Fatal(err)
+	}
+	if err = t2.Set([]byte("b"), []byte("2")); err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan error)
+	go func() {
+		done <- t1.Set([]byte("b"), []byte("1"))
+	}()
+	// Wait until t1 is blocked on the lock held by t2.
+	for {
+		d.locks.mu.Lock()
+		_, waiting := d.locks.waitFor[t1.id]
+		d.locks.mu.Unlock()
+		if waiting {
+			break
+		}
+		time.Sleep(time.Millisecond)
+	}
+
+	if err = t2.Set([]byte("a"), []byte("2")); !errors.Is(err, ErrDeadlock) {
+		t.Fatalf("expected ErrDeadlock, got %v", err)
+	}
+	t2.Rollback()
+
+	if err = <-done; err != nil {
+		t.Fatal(err)
+	}
+	if err = t1.Commit(); err != nil {
+		t.Fatal(err)
+	}
+	v, err := d.Get([]byte("b"))
+	if err != nil || string(v) != "1" {
+		t.Fatalf("unexpected value %q, %v", v, err)
+	}
+}
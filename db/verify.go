@@ -0,0 +1,152 @@
+package db
+
+import (
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// VerifyProblem is an inconsistency found by Verify.
+type VerifyProblem struct {
+	FileNum int // number of the file concerned, or 0 for the whole DB
+	Level   int // level of the table concerned, or -1 if it is not live
+	Err     error
+}
+
+func (p VerifyProblem) String() string {
+	switch {
+	case p.FileNum == 0:
+		return p.Err.Error()
+	case p.Level < 0:
+		return fmt.Sprintf("file %d: %v", p.FileNum, p.Err)
+	default:
+		return fmt.Sprintf("L%d table %d: %v", p.Level, p.FileNum, p.Err)
+	}
+}
+
+// VerifyReport is the result of Verify.
+type VerifyReport struct {
+	Tables   int // number of live tables verified
+	Problems []VerifyProblem
+
+	// Obsolete lists the numbers of the sstables and WALs in the data
+	// directory that the manifest does not reference, such as tables
+	// replaced by a compaction that open iterators still read. They are
+	// deleted once no longer used, and are not inconsistencies. Tables that
+	// running flushes and compactions are writing are not listed.
+	Obsolete []int
+}
+
+// OK reports whether no problem was found.
+func (r *VerifyReport) OK() bool {
+	return len(r.Problems) == 0
+}
+
+func (r *VerifyReport) String() string {
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "%d tables verified, %d problems, %d obsolete files", r.Tables, len(r.Problems), len(r.Obsolete))
+	for _, p := range r.Problems {
+		fmt.Fprintf(&sb, "\n  %s", p)
+	}
+	return sb.String()
+}
+
+// Verify checks the consistency of the DB. Every live sstable is read in
+// full: its footer, the block handles of its index, the checksums of its
+// blocks, the order of its keys within and across blocks, and that index
+// keys bound their blocks. Verify also checks that the manifest matches the
+// files on disk, listing the files it does not reference, and that the
+// tables of every level below level 0 do not overlap. The DB is verified as
+// of the call, while flushes and compactions go on.
+//
+// Problems found are listed in the report; the error is only set when the
+// check itself could not run. Tables written before block checksums were
+// introduced are verified without them.
+func (d *DB) Verify() (*VerifyReport, error) {
+	// The tables of the pinned version stay on disk until it is released.
+	d.mu.Lock()
+	if d.closed {
+		d.mu.Unlock()
+		return nil, ErrClosed
+	}
+	files, err := d.dataStorage.ListFiles()
+	if err != nil {
+		d.mu.Unlock()
+		return nil, err
+	}
+	v := d.refVersion()
+	logNum := d.logNum
+	writing := d.protectedOutputs()
+	d.mu.Unlock()
+	defer d.releaseVersion(v)
+
+	report := &VerifyReport{}
+	addProblem := func(fileNum, level int, err error) {
+		report.Problems = append(report.Problems, VerifyProblem{FileNum: fileNum, Level: level, Err: err})
+	}
+
+	live := make(map[int]bool)
+	for level, tables := range v.levels {
+		for i, t := range tables {
+			report.Tables++
+			live[t.FileNum()] = true
+			for _, err := range d.verifyTable(t) {
+				addProblem(t.FileNum(), level, err)
+			}
+			if level == 0 || i == 0 {
+				continue
+			}
+			if prev := tables[i-1]; d.cmp.Compare(prev.largest, t.smallest) >= 0 {
+				addProblem(t.FileNum(), level, fmt.Errorf("key range overlaps table %d", prev.FileNum()))
+			}
+		}
+	}
+
+	// Tables that running flushes, compactions and ingestions write are
+	// not referenced yet, but not obsolete either.
+	for _, f := range files {
+		inProgress := writing > 0 && f.FileNum() >= writing
+		if f.IsSSTable() && !live[f.FileNum()] && !inProgress || f.IsWAL() && f.FileNum() < logNum {
+			report.Obsolete = append(report.Obsolete, f.FileNum())
+		}
+	}
+	slices.Sort(report.Obsolete)
+	return report, nil
+}
+
+// Reads the sstable t in full and checks it against its manifest entry.
+func (d *DB) verifyTable(t *tableMeta) []error {
+	f, err := d.dataStorage.OpenFileForReading(t.FileMetadata)
+	if err != nil {
+		return []error{err}
+	}
+	r, err := sstable.NewReader(f, d.cmp)
+	if err != nil {
+		f.Close()
+		return []error{err}
+	}
+	defer r.Close()
+
+	errs := r.Verify()
+	info, err := f.Stat()
+	if err != nil {
+		return append(errs, err)
+	}
+	if info.Size() != t.size {
+		errs = append(errs, fmt.Errorf("size is %d bytes, the manifest says %d", info.Size(), t.size))
+	}
+	if len(errs) > 0 {
+		// The key range cannot be read safely from a damaged table.
+		return errs
+	}
+	smallest, largest, err := r.KeyRange()
+	if err != nil {
+		return append(errs, err)
+	}
+	if d.cmp.Compare(smallest, t.smallest) != 0 || d.cmp.Compare(largest, t.largest) != 0 {
+		errs = append(errs, fmt.Errorf("key range is [%q, %q], the manifest says [%q, %q]", smallest, largest, t.smallest, t.largest))
+	}
+	return errs
+}
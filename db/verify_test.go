@@ -0,0 +1,135 @@
+package db
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// Overwrites a few bytes of the first data block of an sstable.
+func corruptTable(t *testing.T, path string) {
+	f, err := os.OpenFile(path, os.O_WRONLY, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if _, err = f.WriteAt([]byte{0xff, 0xff, 0xff, 0xff}, 16); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 5000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("value%05d", i)))
+	}
+	if _, err = d.CompactRange(nil, nil).Wait(); err != nil {
+		t.Fatal(err)
+	}
+	// Verify does not wait for compactions, which must not merge the table
+	// flushed below.
+	waitForBackgroundWork(d)
+	for i := 0; i < 100; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("new"))
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	report, err := d.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || report.Tables < 2 {
+		t.Fatalf("expected a clean report of several tables, got %s", report)
+	}
+
+	// A damaged data block of a live table and a table missing from the
+	// manifest.
+	corrupted := d.levels[0][0]
+	corruptTable(t, d.dataStorage.Path(corrupted.FileMetadata))
+	writeExternalFile(t, filepath.Join(dir, "999999.sst"), 0, 10, "v")
+
+	report, err = d.Verify()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(report.Problems) != 1 {
+		t.Fatalf("expected a single problem, got %s", report)
+	}
+	if p := report.Problems[0]; p.FileNum != corrupted.FileNum() || p.Level != 0 || !errors.Is(p.Err, sstable.ErrCorruptedTable) {
+		t.Fatalf("expected table %d to be corrupted, got %s", corrupted.FileNum(), p)
+	}
+	if obsolete := report.Obsolete; len(obsolete) == 0 || obsolete[len(obsolete)-1] != 999999 {
+		t.Fatalf("expected the sstable missing from the manifest to be obsolete, got %v", obsolete)
+	}
+}
+
+// Holds back compactions into the bottom level once they have written some
+// entries, until release is closed.
+type gatedFilter struct {
+	n                int
+	blocked, release chan struct{}
+}
+
+func (f *gatedFilter) Filter(level int, key, val []byte) (FilterDecision, []byte) {
+	if level != numLevels-1 {
+		return FilterKeep, nil
+	}
+	if f.n++; f.n == 100 {
+		close(f.blocked)
+		<-f.release
+	}
+	return FilterKeep, nil
+}
+
+func TestVerifyDuringCompaction(t *testing.T) {
+	filter := &gatedFilter{blocked: make(chan struct{}), release: make(chan struct{})}
+	d, err := Open(t.TempDir(), &Options{CompactionFilter: filter})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 1000; i++ {
+		d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte("v"))
+	}
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	waitForBackgroundWork(d)
+
+	// The output of the compaction is on disk, but not in the manifest yet.
+	job := d.CompactRange(nil, nil)
+	<-filter.blocked
+	report, err := d.Verify()
+	close(filter.release)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !report.OK() || len(report.Obsolete) != 0 {
+		t.Fatalf("expected a clean report without obsolete files, got %s, obsolete %v", report, report.Obsolete)
+	}
+	if _, err = job.Wait(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVerifyClosed(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Close()
+	if _, err = d.Verify(); err != ErrClosed {
+		t.Fatalf("expected ErrClosed, got %v", err)
+	}
+}
@@ -0,0 +1,183 @@
+package db
+
+import (
+	"sort"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+	"github.com/wubba-com/lsm-tree/db/storage"
+	"github.com/wubba-com/lsm-tree/sstable"
+)
+
+// Sstables are organized in levels. Level 0 holds flushed memtables, whose
+// key ranges may overlap, ordered from oldest to newest. Every other level
+// holds tables with disjoint key ranges ordered by key. Data in a lower
+// level is always newer than data in a higher one.
+const numLevels = 7
+
+// tableMeta describes a live sstable.
+type tableMeta struct {
+	*storage.FileMetadata
+	size     int64  // file size in bytes
+	smallest []byte // smallest key stored in the table
+	largest  []byte // largest key stored in the table
+	refs     int    // number of versions that include the table; guarded by DB.mu
+}
+
+// version is the set of tables that made up the DB at some point. The
+// current version is referenced by the DB, and older ones by the reads that
+// still use their tables. Every version references its tables in turn, and
+// a table is deleted once no version references it.
+type version struct {
+	levels [numLevels][]*tableMeta
+	refs   int // guarded by DB.mu
+}
+
+// Makes d.levels the current version. Must be called with d.mu held, once
+// the manifest describing d.levels has been written.
+func (d *DB) installVersion() {
+	v := &version{levels: d.levels, refs: 1}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.refs++
+		}
+	}
+	prev := d.current
+	d.current = v
+	if prev != nil {
+		d.unrefVersion(prev)
+	}
+}
+
+// Returns the current version, which the caller must release with
+// releaseVersion once it no longer reads its tables. Must be called with
+// d.mu held.
+func (d *DB) refVersion() *version {
+	d.current.refs++
+	return d.current
+}
+
+// Releases a version returned by refVersion. Must be called without d.mu
+// held.
+func (d *DB) releaseVersion(v *version) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.unrefVersion(v)
+}
+
+// Drops a reference to v and schedules the deletion of the tables that are
+// no longer referenced by any version. Must be called with d.mu held.
+func (d *DB) unrefVersion(v *version) {
+	v.refs--
+	if v.refs > 0 {
+		return
+	}
+	for _, tables := range v.levels {
+		for _, t := range tables {
+			t.refs--
+			if t.refs == 0 {
+				d.scheduleDeletion(t.FileMetadata)
+			}
+		}
+	}
+}
+
+func (t *tableMeta) overlaps(cmp comparer.Comparer, smallest, largest []byte) bool {
+	return cmp.Compare(t.smallest, largest) <= 0 && cmp.Compare(smallest, t.largest) <= 0
+}
+
+func (t *tableMeta) containsKey(cmp comparer.Comparer, key []byte) bool {
+	return t.overlaps(cmp, key, key)
+}
+
+func levelOverlaps(cmp comparer.Comparer, tables []*tableMeta, smallest, largest []byte) bool {
+	for _, t := range tables {
+		if t.overlaps(cmp, smallest, largest) {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns a copy of the level with t inserted at its position by key.
+func insertIntoLevel(cmp comparer.Comparer, tables []*tableMeta, t *tableMeta) []*tableMeta {
+	i := sort.Search(len(tables), func(i int) bool {
+		return cmp.Compare(tables[i].smallest, t.smallest) > 0
+	})
+	level := make([]*tableMeta, 0, len(tables)+1)
+	level = append(level, tables[:i]...)
+	level = append(level, t)
+	return append(level, tables[i:]...)
+}
+
+// A table considered by a lookup.
+type tableLookup struct {
+	*tableMeta
+	level    int
+	ruledOut bool // the key range of the table excludes the key
+}
+
+// Returns the tables that may contain key from newest to oldest. With
+// withRuledOut, the tables whose key range was checked and found not to
+// contain key are returned too, in the order they were considered.
+func tablesForKey(cmp comparer.Comparer, levels *[numLevels][]*tableMeta, key []byte, withRuledOut bool) []tableLookup {
+	var tables []tableLookup
+	consider := func(t *tableMeta, level int) {
+		ruledOut := !t.containsKey(cmp, key)
+		if !ruledOut || withRuledOut {
+			tables = append(tables, tableLookup{t, level, ruledOut})
+		}
+	}
+	for i := len(levels[0]) - 1; i >= 0; i-- {
+		consider(levels[0][i], 0)
+	}
+	for level := 1; level < numLevels; level++ {
+		files := levels[level]
+		i := sort.Search(len(files), func(i int) bool {
+			return cmp.Compare(files[i].largest, key) >= 0
+		})
+		if i < len(files) {
+			consider(files[i], level)
+		}
+	}
+	return tables
+}
+
+// Returns all tables from newest to oldest.
+func allTables(levels *[numLevels][]*tableMeta) []*tableMeta {
+	var tables []*tableMeta
+	for i := len(levels[0]) - 1; i >= 0; i-- {
+		tables = append(tables, levels[0][i])
+	}
+	for level := 1; level < numLevels; level++ {
+		tables = append(tables, levels[level]...)
+	}
+	return tables
+}
+
+// Reads the key range and size of an sstable that is not described by the
+// manifest yet.
+func (d *DB) loadTableMeta(meta *storage.FileMetadata) (*tableMeta, error) {
+	f, err := d.dataStorage.OpenFileForReading(meta)
+	if err != nil {
+		return nil, err
+	}
+	r, err := sstable.NewReader(f, d.cmp)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+	defer r.Close()
+
+	t := &tableMeta{FileMetadata: meta}
+	t.smallest, t.largest, err = r.KeyRange()
+	if err != nil {
+		return nil, err
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	t.size = info.Size()
+
+	return t, nil
+}
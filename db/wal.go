@@ -0,0 +1,200 @@
+package db
+
+import (
+	"encoding/binary"
+	"hash/crc32"
+	"os"
+	"slices"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+// Size of the [crc32][length] header of a WAL record.
+const walHeaderSize = 8
+
+// wal is the write-ahead log the DB currently appends to. Every memtable
+// starts in a WAL of its own, and a WAL can be deleted once all memtables
+// with writes in it have been flushed.
+//
+// A WAL is a sequence of records, each holding an encoded batch:
+// [crc32][length][batch], with the little-endian checksum covering the
+// batch. A record that was only partly written before a crash ends the log.
+type wal struct {
+	f             *os.File // nil while the DB is read-only or recovering
+	num           int      // number of the current WAL
+	switchPending bool     // the next group commit starts a new WAL
+	busy          bool     // a group commit is appending to the WAL or inserting its writes
+}
+
+func appendWALRecord(buf []byte, b *Batch) []byte {
+	start := len(buf)
+	buf = append(buf, make([]byte, walHeaderSize)...)
+	buf = b.encode(buf)
+	payload := buf[start+walHeaderSize:]
+	binary.LittleEndian.PutUint32(buf[start:], crc32.ChecksumIEEE(payload))
+	binary.LittleEndian.PutUint32(buf[start+4:], uint32(len(payload)))
+	return buf
+}
+
+// Decodes the records of a WAL. Returns the batches read and the offset of
+// the first record that could not be decoded, which is len(buf) if there is
+// none.
+func decodeWALRecords(buf []byte) ([]*Batch, int) {
+	var batches []*Batch
+	off := 0
+	for len(buf)-off >= walHeaderSize {
+		checksum := binary.LittleEndian.Uint32(buf[off:])
+		n := int(binary.LittleEndian.Uint32(buf[off+4:]))
+		if n > len(buf)-off-walHeaderSize {
+			break
+		}
+		payload := buf[off+walHeaderSize : off+walHeaderSize+n]
+		if crc32.ChecksumIEEE(payload) != checksum {
+			break
+		}
+		b, err := decodeBatch(payload)
+		if err != nil {
+			break
+		}
+		batches = append(batches, b)
+		off += walHeaderSize + n
+	}
+	return batches, off
+}
+
+// Makes a new WAL the current one. The previous WAL is synced and closed.
+// Must be called with d.mu held.
+func (d *DB) switchWAL() error {
+	if d.wal.f != nil {
+		if err := d.wal.f.Sync(); err != nil {
+			return err
+		}
+	}
+	meta := d.dataStorage.PrepareNewWAL()
+	f, err := d.dataStorage.OpenFileForWriting(meta)
+	if err != nil {
+		return err
+	}
+	// Writes synced to the WAL must not get lost with its directory entry.
+	err = d.dataStorage.SyncDir()
+	if err != nil {
+		f.Close()
+		os.Remove(d.dataStorage.Path(meta))
+		return err
+	}
+	if d.wal.f != nil {
+		if err := d.wal.f.Close(); err != nil {
+			d.opts.Logger.Errorf("closing WAL %d: %v", d.wal.num, err)
+		}
+	}
+	d.wal.f, d.wal.num, d.wal.switchPending = f, meta.FileNum(), false
+	d.opts.Logger.Debugf("created WAL %d", meta.FileNum())
+	d.opts.EventListener.walCreated(WALCreateInfo{FileNum: meta.FileNum(), Path: d.dataStorage.Path(meta)})
+	return nil
+}
+
+// Syncs and closes the current WAL, if any.
+func (d *DB) closeWAL() error {
+	if d.wal.f == nil {
+		return nil
+	}
+	err := d.wal.f.Sync()
+	if closeErr := d.wal.f.Close(); err == nil {
+		err = closeErr
+	}
+	d.wal.f = nil
+	return err
+}
+
+// Returns the WALs in the data directory that may hold writes which have
+// not been flushed, oldest first.
+func (d *DB) liveWALs() ([]*storage.FileMetadata, error) {
+	files, err := d.dataStorage.ListFiles()
+	if err != nil {
+		return nil, err
+	}
+	var wals []*storage.FileMetadata
+	for _, f := range files {
+		if f.IsWAL() && f.FileNum() >= d.logNum {
+			wals = append(wals, f)
+		}
+	}
+	slices.SortFunc(wals, func(a, b *storage.FileMetadata) int {
+		return a.FileNum() - b.FileNum()
+	})
+	return wals, nil
+}
+
+// Restores the writes that were not flushed before the DB was last closed
+// from the WALs, and opens a new WAL. Unless the DB is read-only, the
+// recovered memtables are flushed right away and the replayed WALs deleted.
+// Must be called from Open.
+func (d *DB) recoverWALs() error {
+	wals, err := d.liveWALs()
+	if err != nil {
+		return err
+	}
+	for _, meta := range wals {
+		buf, err := os.ReadFile(d.dataStorage.Path(meta))
+		if err != nil {
+			return err
+		}
+		batches, off := decodeWALRecords(buf)
+		if off < len(buf) {
+			// The tail of a WAL is lost when the DB crashes while appending.
+			d.opts.Logger.Errorf("WAL %d: ignoring %d bytes of incomplete records at offset %d", meta.FileNum(), len(buf)-off, off)
+		}
+		// Memtables filled up while replaying start in the WAL being replayed.
+		d.wal.num = meta.FileNum()
+		for _, b := range batches {
+			d.insertBatch(b)
+		}
+		d.opts.Logger.Infof("replayed %d batches from WAL %d", len(batches), meta.FileNum())
+	}
+	if d.opts.ReadOnly {
+		return nil
+	}
+
+	if d.memtables.mutable.Size() > 0 {
+		d.rotateMemtables()
+	}
+	err = d.switchWAL()
+	if err != nil {
+		return err
+	}
+	// The mutable memtable is empty, so its writes all go to the new WAL.
+	d.memtables.logNums[len(d.memtables.logNums)-1] = d.wal.num
+
+	if len(d.memtables.queue) > 1 {
+		return d.flushMemtables(nil)
+	}
+	if len(wals) == 0 {
+		return nil
+	}
+	// The replayed WALs were empty.
+	d.logNum = d.wal.num
+	err = d.writeManifest()
+	if err != nil {
+		return err
+	}
+	d.deleteObsoleteWALs()
+	return nil
+}
+
+// Schedules the deletion of the WALs whose writes have all been flushed.
+// Must be called with d.mu held.
+func (d *DB) deleteObsoleteWALs() {
+	if d.opts.ReadOnly {
+		return
+	}
+	files, err := d.dataStorage.ListFiles()
+	if err != nil {
+		d.opts.Logger.Errorf("listing WALs: %v", err)
+		return
+	}
+	for _, f := range files {
+		if f.IsWAL() && f.FileNum() < d.logNum {
+			d.scheduleDeletion(f)
+		}
+	}
+}
@@ -0,0 +1,106 @@
+package db
+
+import (
+	"context"
+	"io"
+	"os"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestGroupCommitFollowerCanceled(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	// Appends to the WAL block until the test reads them from the pipe,
+	// which holds less than a single write.
+	pr, pw, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pr.Close()
+	if _, _, errno := syscall.Syscall(syscall.SYS_FCNTL, pw.Fd(), syscall.F_SETPIPE_SZ, 4096); errno != 0 {
+		t.Fatal(errno)
+	}
+	d.mu.Lock()
+	walFile := d.wal.f
+	d.wal.f = pw
+	d.mu.Unlock()
+
+	waitForWriters := func(n int) {
+		deadline := time.Now().Add(5 * time.Second)
+		for {
+			d.mu.Lock()
+			queued := len(d.writers)
+			d.mu.Unlock()
+			if queued == n {
+				return
+			}
+			if time.Now().After(deadline) {
+				t.Fatalf("expected %d queued writers, got %d", n, queued)
+			}
+			time.Sleep(time.Millisecond)
+		}
+	}
+	set := func(ctx context.Context, key string, value []byte) chan error {
+		errc := make(chan error, 1)
+		go func() {
+			b := NewBatch()
+			b.Set([]byte(key), value)
+			errc <- d.ApplyContext(ctx, b)
+		}()
+		return errc
+	}
+
+	// The leader blocks in its append, and the next writer leads a group
+	// with the two writers queued behind it.
+	big := make([]byte, 8<<10)
+	leaderBatch := NewBatch()
+	leaderBatch.Set([]byte("a"), big)
+	leader := set(context.Background(), "a", big)
+	waitForWriters(1)
+	next := set(context.Background(), "b", big)
+	waitForWriters(2)
+	ctx, cancel := context.WithCancel(context.Background())
+	follower := set(ctx, "c", []byte("v"))
+	waitForWriters(3)
+	last := set(context.Background(), "d", []byte("v"))
+	waitForWriters(4)
+
+	// Once the leader's record is read, the group of the next writer blocks
+	// in its append, and the follower is canceled meanwhile.
+	if _, err = io.ReadFull(pr, make([]byte, len(appendWALRecord(nil, leaderBatch)))); err != nil {
+		t.Fatal(err)
+	}
+	if err = <-leader; err != nil {
+		t.Fatal(err)
+	}
+	cancel()
+	select {
+	case err = <-follower:
+		t.Fatalf("expected the follower to wait for its group, got %v", err)
+	case <-time.After(20 * time.Millisecond):
+	}
+
+	go io.Copy(io.Discard, pr)
+	for _, errc := range []chan error{next, follower, last} {
+		if err = <-errc; err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.mu.Lock()
+	d.wal.f = walFile
+	queued := len(d.writers)
+	d.mu.Unlock()
+	pw.Close()
+	if queued != 0 {
+		t.Fatalf("expected the queue to be empty, got %d writers", queued)
+	}
+	if v, err := d.Get([]byte("c")); err != nil || string(v) != "v" {
+		t.Fatalf("expected the follower's write, got %q, %v", v, err)
+	}
+}
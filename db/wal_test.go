@@ -0,0 +1,250 @@
+package db
+
+import (
+	"fmt"
+	"os"
+	"slices"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/db/storage"
+)
+
+// Stops d as if the process had crashed: memtables are not flushed and the
+// WAL is left as it is.
+func crash(d *DB) {
+	d.mu.Lock()
+	d.closed = true
+	d.workDone.Broadcast()
+	d.mu.Unlock()
+	d.bgWG.Wait()
+	d.wal.f.Close()
+	d.releaseDirLock()
+}
+
+func walFiles(t *testing.T, dir string) []string {
+	var wals []string
+	for _, name := range listDir(t, dir) {
+		if strings.HasSuffix(name, ".log") {
+			wals = append(wals, name)
+		}
+	}
+	return wals
+}
+
+func TestWALRecovery(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Enough writes to flush some memtables before the crash.
+	for i := 0; i < 2000; i++ {
+		if err = d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte(fmt.Sprintf("v%d", i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b := NewBatch()
+	b.Delete([]byte("key0042"))
+	b.Set([]byte("key0043"), []byte("new"))
+	if err = d.Apply(b); err != nil {
+		t.Fatal(err)
+	}
+	crash(d)
+
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 2000; i++ {
+		v, err := d.Get([]byte(fmt.Sprintf("key%04d", i)))
+		switch i {
+		case 42:
+			if err != ErrNotFound {
+				t.Fatalf("expected key0042 to stay deleted, got %q, %v", v, err)
+			}
+		case 43:
+			if err != nil || string(v) != "new" {
+				t.Fatalf("expected %q, got %q, %v", "new", v, err)
+			}
+		default:
+			if err != nil || string(v) != fmt.Sprintf("v%d", i) {
+				t.Fatalf("key%04d: got %q, %v", i, v, err)
+			}
+		}
+	}
+	// The recovered writes are flushed and only the new WAL is left.
+	waitForBackgroundWork(d)
+	if m := d.Metrics(); m.Memtables.Count != 1 || m.Memtables.Size != 0 {
+		t.Fatalf("expected a single empty memtable, got %d of %d bytes", m.Memtables.Count, m.Memtables.Size)
+	}
+	if wals := walFiles(t, dir); len(wals) != 1 {
+		t.Fatalf("expected a single WAL, got %v", wals)
+	}
+}
+
+func TestWALTornRecord(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for i := 0; i < 10; i++ {
+		d.Set([]byte(fmt.Sprintf("key%04d", i)), []byte("v"))
+	}
+	path := d.dataStorage.Path(storage.NewFileMetadata(d.wal.num, storage.FileTypeWAL))
+	crash(d)
+
+	// A record cut short by the crash.
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+	torn := appendWALRecord(nil, NewBatch())
+	if _, err = f.Write(torn[:len(torn)-1]); err != nil {
+		t.Fatal(err)
+	}
+	f.Close()
+
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for i := 0; i < 10; i++ {
+		if _, err = d.Get([]byte(fmt.Sprintf("key%04d", i))); err != nil {
+			t.Fatalf("key%04d: %v", i, err)
+		}
+	}
+}
+
+func TestWriteOptionsSync(t *testing.T) {
+	d, err := Open(t.TempDir(), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	d.Set([]byte("a"), []byte("1"))
+	d.Delete([]byte("b"))
+	if m := d.Metrics(); m.WALWrites != 2 || m.WALSyncs != 0 {
+		t.Fatalf("expected 2 WAL writes without sync, got %d writes, %d syncs", m.WALWrites, m.WALSyncs)
+	}
+	sync := &WriteOptions{Sync: true}
+	if err = d.SetWithOptions([]byte("a"), []byte("2"), sync); err != nil {
+		t.Fatal(err)
+	}
+	if err = d.DeleteWithOptions([]byte("a"), sync); err != nil {
+		t.Fatal(err)
+	}
+	b := NewBatch()
+	b.Set([]byte("c"), []byte("3"))
+	if err = d.ApplyWithOptions(b, sync); err != nil {
+		t.Fatal(err)
+	}
+	if m := d.Metrics(); m.WALSyncs != 3 {
+		t.Fatalf("expected 3 WAL syncs, got %d", m.WALSyncs)
+	}
+
+	if err = d.SyncWAL(); err != nil {
+		t.Fatal(err)
+	}
+	if m := d.Metrics(); m.WALWrites != 5 || m.WALSyncs != 4 {
+		t.Fatalf("expected SyncWAL to sync without writing, got %d writes, %d syncs", m.WALWrites, m.WALSyncs)
+	}
+}
+
+func TestGroupCommit(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	const writers, writesPerWriter = 16, 50
+	var wg sync.WaitGroup
+	for w := 0; w < writers; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			for i := 0; i < writesPerWriter; i++ {
+				key := []byte(fmt.Sprintf("key%02d-%02d", w, i))
+				if err := d.SetWithOptions(key, []byte("v"), &WriteOptions{Sync: true}); err != nil {
+					t.Error(err)
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+
+	m := d.Metrics()
+	if m.WALSyncs > writers*writesPerWriter || m.WALSyncs != m.WALWrites {
+		t.Fatalf("expected at most one sync per write and per WAL write, got %d syncs, %d writes", m.WALSyncs, m.WALWrites)
+	}
+	t.Logf("%d writes in %d WAL writes", writers*writesPerWriter, m.WALWrites)
+	crash(d)
+
+	d, err = Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	for w := 0; w < writers; w++ {
+		for i := 0; i < writesPerWriter; i++ {
+			key := []byte(fmt.Sprintf("key%02d-%02d", w, i))
+			if _, err := d.Get(key); err != nil {
+				t.Fatalf("%s: %v", key, err)
+			}
+		}
+	}
+}
+
+func TestReadOnlyReplaysWAL(t *testing.T) {
+	dir := t.TempDir()
+	d, err := Open(dir, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("a"), []byte("1"))
+	crash(d)
+	before := listDir(t, dir)
+
+	ro, err := Open(dir, &Options{ReadOnly: true})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v, err := ro.Get([]byte("a")); err != nil || string(v) != "1" {
+		t.Fatalf("expected %q, got %q, %v", "1", v, err)
+	}
+	if err = ro.Close(); err != nil {
+		t.Fatal(err)
+	}
+	if after := listDir(t, dir); !slices.Equal(before, after) {
+		t.Fatalf("expected the directory to stay unchanged, got %v, want %v", after, before)
+	}
+}
+
+func TestWALCreatedEvent(t *testing.T) {
+	var created []int
+	opts := &Options{EventListener: &EventListener{
+		WALCreated: func(info WALCreateInfo) { created = append(created, info.FileNum) },
+	}}
+	d, err := Open(t.TempDir(), opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+	d.Set([]byte("a"), []byte("1"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if len(created) != 2 || created[1] != d.wal.num || d.logNum != d.wal.num {
+		t.Fatalf("expected the flush to switch to a new WAL, got WALs %v, current %d, log number %d", created, d.wal.num, d.logNum)
+	}
+}
@@ -0,0 +1,154 @@
+package db
+
+import (
+	"context"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Delay of every write while writes are slowed down.
+const slowdownDelay = time.Millisecond
+
+// WriteStallCondition tells how much writes are held back.
+type WriteStallCondition int
+
+const (
+	WriteStallNone     WriteStallCondition = iota
+	WriteStallSlowdown                     // every write is delayed
+	WriteStallStop                         // writes block until background work catches up
+)
+
+func (c WriteStallCondition) String() string {
+	switch c {
+	case WriteStallSlowdown:
+		return "slowdown"
+	case WriteStallStop:
+		return "stop"
+	}
+	return "none"
+}
+
+// WriteStallInfo describes a write stall.
+type WriteStallInfo struct {
+	Condition WriteStallCondition
+	Reason    string
+}
+
+// WriteStallStats counts write stalls since the DB was opened.
+type WriteStallStats struct {
+	Slowdowns uint64        // writes delayed
+	Stops     uint64        // writes blocked
+	Duration  time.Duration // total time spent in stalls, including the current one
+}
+
+type writeStall struct {
+	info  WriteStallInfo
+	since time.Time // start of the current stall
+
+	slowdowns, stops atomic.Uint64
+	duration         atomic.Int64
+}
+
+func (d *DB) WriteStallStats() WriteStallStats {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	stats := WriteStallStats{
+		Slowdowns: d.stall.slowdowns.Load(),
+		Stops:     d.stall.stops.Load(),
+		Duration:  time.Duration(d.stall.duration.Load()),
+	}
+	if d.stall.info.Condition != WriteStallNone {
+		stats.Duration += time.Since(d.stall.since)
+	}
+	return stats
+}
+
+// Returns whether writes should currently be delayed or blocked because
+// flushes or compactions fall behind.
+func (d *DB) writeStallCondition() WriteStallInfo {
+	immutable := len(d.memtables.queue) - 1
+	l0 := len(d.levels[0])
+	switch {
+	case immutable >= d.opts.MemtableStopThreshold:
+		return WriteStallInfo{WriteStallStop, fmt.Sprintf("too many immutable memtables (%d)", immutable)}
+	case l0 >= d.opts.L0StopThreshold:
+		return WriteStallInfo{WriteStallStop, fmt.Sprintf("too many level 0 tables (%d)", l0)}
+	case immutable >= d.opts.MemtableSlowdownThreshold:
+		return WriteStallInfo{WriteStallSlowdown, fmt.Sprintf("too many immutable memtables (%d)", immutable)}
+	case l0 >= d.opts.L0SlowdownThreshold:
+		return WriteStallInfo{WriteStallSlowdown, fmt.Sprintf("too many level 0 tables (%d)", l0)}
+	}
+	return WriteStallInfo{}
+}
+
+// Records the current stall condition and reports changes to the event
+// listener.
+func (d *DB) updateWriteStall() WriteStallCondition {
+	info := d.writeStallCondition()
+	prev := d.stall.info
+	if info.Condition == prev.Condition {
+		return info.Condition
+	}
+
+	now := time.Now()
+	if prev.Condition != WriteStallNone {
+		d.stall.duration.Add(int64(now.Sub(d.stall.since)))
+		d.opts.EventListener.writeStallEnd(prev)
+	}
+	d.stall.info, d.stall.since = info, now
+	if info.Condition != WriteStallNone {
+		d.opts.Logger.Infof("write stall: %s, %s", info.Condition, info.Reason)
+		d.opts.EventListener.writeStallBegin(info)
+	}
+	return info.Condition
+}
+
+// Waits until a write may proceed: past the slowdown thresholds every write
+// is delayed once, past the stop thresholds writes block until background
+// work catches up. Fails if the DB is closed, background work fails or ctx
+// is done meanwhile. Must be called with d.mu held, which is released while
+// waiting.
+func (d *DB) makeRoomForWrite(ctx context.Context) error {
+	var delayed, stopped bool
+	for {
+		if err := d.checkWritable(); err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch d.updateWriteStall() {
+		case WriteStallStop:
+			if !stopped {
+				stopped = true
+				d.stall.stops.Add(1)
+				// Wake up once ctx is done.
+				stop := context.AfterFunc(ctx, func() {
+					d.mu.Lock()
+					defer d.mu.Unlock()
+					d.workDone.Broadcast()
+				})
+				defer stop()
+			}
+			d.maybeScheduleFlush()
+			d.maybeScheduleCompaction()
+			d.workDone.Wait()
+		case WriteStallSlowdown:
+			if delayed {
+				return nil
+			}
+			delayed = true
+			d.stall.slowdowns.Add(1)
+			d.mu.Unlock()
+			select {
+			case <-time.After(slowdownDelay):
+			case <-ctx.Done():
+			}
+			d.mu.Lock()
+		default:
+			return nil
+		}
+	}
+}
@@ -0,0 +1,128 @@
+package db
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// Blocks compactions into the bottom level until release is closed.
+type blockingFilter chan struct{}
+
+func (f blockingFilter) Filter(level int, key, val []byte) (FilterDecision, []byte) {
+	if level == numLevels-1 {
+		<-f
+	}
+	return FilterKeep, nil
+}
+
+func TestWriteStalls(t *testing.T) {
+	var mu sync.Mutex
+	var events []string
+	listener := &EventListener{
+		WriteStallBegin: func(info WriteStallInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, "begin "+info.Condition.String())
+		},
+		WriteStallEnd: func(info WriteStallInfo) {
+			mu.Lock()
+			defer mu.Unlock()
+			events = append(events, "end "+info.Condition.String())
+		},
+	}
+	// Compactions are held back until writes have been blocked.
+	release := make(chan struct{})
+	d, err := Open(t.TempDir(), &Options{
+		L0SlowdownThreshold: 1,
+		L0StopThreshold:     2,
+		L0CompactionTrigger: 2,
+		EventListener:       listener,
+		CompactionFilter:    blockingFilter(release),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 0; i < 5000; i++ {
+			d.Set([]byte(fmt.Sprintf("key%05d", i)), []byte(fmt.Sprintf("val%05d", i)))
+		}
+	}()
+	deadline := time.Now().Add(5 * time.Second)
+	for d.WriteStallStats().Stops == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("expected writes to be blocked")
+		}
+		time.Sleep(time.Millisecond)
+	}
+	close(release)
+	<-done
+	waitForBackgroundWork(d)
+
+	if stats := d.WriteStallStats(); stats.Duration == 0 {
+		t.Fatalf("expected stall duration to be counted, got %+v", stats)
+	}
+	d.mu.Lock()
+	l0 := len(d.levels[0])
+	d.mu.Unlock()
+	if l0 >= 2 {
+		t.Fatalf("expected level 0 to be compacted, got %d tables", l0)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	var begins, ends int
+	var stopped bool
+	for _, e := range events {
+		if strings.HasPrefix(e, "begin") {
+			begins++
+		} else {
+			ends++
+		}
+		stopped = stopped || e == "begin stop"
+	}
+	if !stopped || begins-ends > 1 {
+		t.Fatalf("unbalanced stall events %v", events)
+	}
+
+	for i := 0; i < 5000; i += 500 {
+		key := fmt.Sprintf("key%05d", i)
+		if v, err := d.Get([]byte(key)); err != nil || string(v) != fmt.Sprintf("val%05d", i) {
+			t.Fatalf("unexpected value of %s: %q, %v", key, v, err)
+		}
+	}
+}
+
+func TestWriteSlowdown(t *testing.T) {
+	var began WriteStallInfo
+	d, err := Open(t.TempDir(), &Options{
+		L0SlowdownThreshold: 1,
+		L0CompactionTrigger: 10,
+		EventListener: &EventListener{
+			WriteStallBegin: func(info WriteStallInfo) { began = info },
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { d.Close() })
+
+	d.Set([]byte("a"), []byte("1"))
+	if _, err = d.Flush().Wait(); err != nil {
+		t.Fatal(err)
+	}
+	d.Set([]byte("b"), []byte("2"))
+
+	if stats := d.WriteStallStats(); stats.Slowdowns != 1 || stats.Stops != 0 {
+		t.Fatalf("expected one delayed write, got %+v", stats)
+	}
+	if began.Condition != WriteStallSlowdown || began.Reason != "too many level 0 tables (1)" {
+		t.Fatalf("unexpected stall %+v", began)
+	}
+}
@@ -6,6 +6,7 @@ import (
 	"fmt"
 	"log"
 	"os"
+	"os/signal"
 
 	"github.com/go-faker/faker/v4"
 	"github.com/wubba-com/lsm-tree/cli"
@@ -14,7 +15,7 @@ import (
 
 const dataFolder = "demo"
 
-var shouldReset, shouldSeed *bool
+var shouldReset, shouldSeed, shouldRepair *bool
 var seedNumRecords *int
 
 func main() {
@@ -23,8 +24,15 @@ func main() {
 	if *shouldReset {
 		eraseDataFolder()
 	}
+	if *shouldRepair {
+		report, err := db.Repair(dataFolder, nil)
+		if err != nil {
+			log.Fatal(err)
+		}
+		fmt.Println(report)
+	}
 
-	d, err := db.Open(dataFolder)
+	d, err := db.Open(dataFolder, nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -35,12 +43,23 @@ func main() {
 
 	scanner := bufio.NewScanner(os.Stdin)
 	demo := cli.NewCLI(scanner, d)
+
+	// Close the DB on Ctrl+C instead of dropping the memtables.
+	interrupt := make(chan os.Signal, 1)
+	signal.Notify(interrupt, os.Interrupt)
+	go func() {
+		<-interrupt
+		fmt.Println()
+		demo.Exit()
+	}()
+
 	demo.Start()
 }
 
 func setupFlags() {
 	shouldReset = flag.Bool("reset", false, "Reset the database by erasing its folder before startup.")
 	shouldSeed = flag.Bool("seed", false, "Seed the database using records created with go-faker.")
+	shouldRepair = flag.Bool("repair", false, "Repair the database from the files that survive in its folder before startup.")
 	seedNumRecords = flag.Int("records", 1000, "Amount of records to seed the database with upon startup.")
 	flag.Usage = func() {
 		fmt.Println("\nDB CLI\n\nArguments:")
@@ -60,6 +79,8 @@ func seedDatabaseWithTestRecords(d *db.DB) {
 	for i := 0; i < *seedNumRecords; i++ {
 		k := []byte(faker.Word() + faker.Word())
 		v := []byte(faker.Word() + faker.Word())
-		d.Set(k, v)
+		if err := d.Set(k, v); err != nil {
+			log.Fatal(err)
+		}
 	}
 }
@@ -20,7 +20,7 @@ func init() {
 }
 
 func BenchmarkSSTSearch(b *testing.B) {
-	d, err := db.Open("demo")
+	d, err := db.Open("demo", nil)
 	if err != nil {
 		log.Fatal(err)
 	}
@@ -1,6 +1,9 @@
 package memtable
 
 import (
+	"encoding/binary"
+
+	"github.com/wubba-com/lsm-tree/comparer"
 	"github.com/wubba-com/lsm-tree/memtable/encoder"
 	"github.com/wubba-com/lsm-tree/skiplist"
 )
@@ -9,14 +12,19 @@ import (
 
 type Memtable struct {
 	sl        *skiplist.SkipList
+	cmp       comparer.Comparer
 	encoder   *encoder.Encoder
-	sizeUsed  int // The approximate amount of space used by the Memtable so far (in bytes).
-	sizeLimit int // The maximum allowed size of the Memtable (in bytes).
+	seqs      *skiplist.SkipList // Sequence number of the latest write to each key, ordered by cmp.
+	lastSeq   uint64             // Sequence number of the latest write to the Memtable.
+	sizeUsed  int                // The approximate amount of space used by the Memtable so far (in bytes).
+	sizeLimit int                // The maximum allowed size of the Memtable (in bytes).
 }
 
-func NewMemtable(sizeLimit int) *Memtable {
+func NewMemtable(sizeLimit int, cmp comparer.Comparer) *Memtable {
 	m := &Memtable{
-		sl:        skiplist.NewSkipList(),
+		sl:        skiplist.NewSkipListWithComparer(cmp),
+		cmp:       cmp,
+		seqs:      skiplist.NewSkipListWithComparer(cmp),
 		sizeLimit: sizeLimit,
 	}
 	return m
@@ -45,20 +53,73 @@ func (m *Memtable) getSize(key, val []byte) int {
 	return len(key) + len(val)
 }
 
-func (m *Memtable) Insert(key, val []byte) {
+func (m *Memtable) Insert(key, val []byte, seq uint64) {
 	m.sl.Insert(key, m.encoder.Encode(encoder.OpKindSet, val))
 	m.sizeUsed += m.getSize(key, val) + 1
+	m.trackSeq(key, seq)
 }
 
-func (m *Memtable) InsertTombstone(key []byte) {
+func (m *Memtable) InsertTombstone(key []byte, seq uint64) {
 	m.sl.Insert(key, m.encoder.Encode(encoder.OpKindDelete, nil))
 	m.sizeUsed += 1
+	m.trackSeq(key, seq)
+}
+
+func (m *Memtable) trackSeq(key []byte, seq uint64) {
+	m.seqs.Insert(key, binary.LittleEndian.AppendUint64(nil, seq))
+	if seq > m.lastSeq {
+		m.lastSeq = seq
+	}
+}
+
+// Seq returns the sequence number of the latest write to key. Keys are
+// matched with the comparer of the Memtable, like in Get.
+func (m *Memtable) Seq(key []byte) (uint64, bool) {
+	v, err := m.seqs.Find(key)
+	if err != nil {
+		return 0, false
+	}
+	return binary.LittleEndian.Uint64(v), true
+}
+
+// LastSeq returns the sequence number of the latest write to the Memtable.
+func (m *Memtable) LastSeq() uint64 {
+	return m.lastSeq
 }
 
 func (m *Memtable) Size() int {
 	return m.sizeUsed
 }
 
+// Overlaps reports whether the Memtable holds a key in [smallest, largest].
+func (m *Memtable) Overlaps(smallest, largest []byte) bool {
+	i := m.sl.Seek(smallest)
+	if !i.HasNext() {
+		return false
+	}
+	key, _ := i.Next()
+	return m.cmp.Compare(key, largest) <= 0
+}
+
+// RangeSize returns the number of key and value bytes of the entries with
+// keys in [start, end], and the number of those entries. A nil start or end
+// leaves the range unbounded on that side.
+func (m *Memtable) RangeSize(start, end []byte) (size, count int) {
+	i := m.sl.Iterator()
+	if start != nil {
+		i = m.sl.Seek(start)
+	}
+	for i.HasNext() {
+		key, val := i.Next()
+		if end != nil && m.cmp.Compare(key, end) > 0 {
+			break
+		}
+		size += len(key) + len(val)
+		count++
+	}
+	return size, count
+}
+
 func (m *Memtable) Iterator() *skiplist.Iterator {
 	return m.sl.Iterator()
 }
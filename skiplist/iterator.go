@@ -20,3 +20,9 @@ func (i *Iterator) Next() ([]byte, []byte) {
 	}
 	return i.current.key, i.current.val
 }
+
+// Seek returns an iterator whose first Next returns the smallest key >= key.
+func (sl *SkipList) Seek(key []byte) *Iterator {
+	_, journey := sl.search(key)
+	return &Iterator{current: journey[0]}
+}
@@ -1,10 +1,10 @@
 package skiplist
 
 import (
-	"bytes"
 	"errors"
 	"math"
 
+	"github.com/wubba-com/lsm-tree/comparer"
 	"github.com/wubba-com/lsm-tree/skiplist/fastrand"
 )
 
@@ -29,7 +29,11 @@ func init() {
 }
 
 func NewSkipList() *SkipList {
-	sl := &SkipList{}
+	return NewSkipListWithComparer(comparer.Bytewise)
+}
+
+func NewSkipListWithComparer(cmp comparer.Comparer) *SkipList {
+	sl := &SkipList{cmp: cmp}
 	sl.head = &node{}
 	sl.height = 1
 
@@ -45,6 +49,7 @@ type node struct {
 type SkipList struct {
 	head   *node
 	height int
+	cmp    comparer.Comparer
 }
 
 func randomHeight() int {
@@ -94,7 +99,7 @@ func (sl *SkipList) search(key []byte) (*node, [MaxHeight]*node) {
 	for level := sl.height - 1; level >= 0; level-- {
 		for next = prev.tower[level]; next != nil; next = prev.tower[level] {
 			// если key < или == next.key
-			if bytes.Compare(key, next.key) <= 0 {
+			if sl.cmp.Compare(key, next.key) <= 0 {
 				break
 			}
 			prev = next
@@ -103,7 +108,7 @@ func (sl *SkipList) search(key []byte) (*node, [MaxHeight]*node) {
 		journey[level] = prev
 	}
 
-	if next != nil && bytes.Equal(key, next.key) {
+	if next != nil && sl.cmp.Compare(key, next.key) == 0 {
 		return next, journey
 	}
 
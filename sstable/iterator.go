@@ -0,0 +1,76 @@
+package sstable
+
+import "fmt"
+
+// Iterator последовательно обходит все записи *.sst файла в порядке возрастания ключей.
+// Значения возвращаются в закодированном виде (см. encoder.EncodedValue).
+type Iterator struct {
+	r        *Reader
+	index    *readerBlock
+	indexPos int // номер следующего блока данных в индексном блоке
+
+	keys, vals [][]byte // записи текущего блока данных
+	pos        int      // номер следующей записи в текущем блоке данных
+	err        error
+}
+
+func (r *Reader) Iterator() (*Iterator, error) {
+	index, err := r.readIndexBlock()
+	if err != nil {
+		return nil, err
+	}
+	// Индексный блок должен пережить чтение блоков данных.
+	r.indexBuf = nil
+
+	return &Iterator{r: r, index: index}, nil
+}
+
+// Загрузить следующий блок данных.
+func (i *Iterator) loadNextBlock() error {
+	indexEntry := i.index.readValAt(i.indexPos)
+	i.indexPos++
+
+	// Каждый блок распаковывается в собственный буфер, поэтому
+	// возвращенные ключи и значения остаются валидными.
+	block, err := i.r.readDataBlock(indexEntry, nil)
+	if err != nil {
+		return err
+	}
+	i.keys, i.vals, err = block.entries()
+	if err != nil {
+		return err
+	}
+	for _, val := range i.vals {
+		if len(val) < 1 {
+			return fmt.Errorf("%w: entry without an operation kind", ErrCorruptedTable)
+		}
+	}
+	i.pos = 0
+
+	return nil
+}
+
+func (i *Iterator) HasNext() bool {
+	for i.err == nil && i.pos >= len(i.keys) {
+		if i.indexPos >= i.index.numOffsets {
+			return false
+		}
+		i.err = i.loadNextBlock()
+	}
+	return i.err == nil
+}
+
+func (i *Iterator) Next() ([]byte, []byte) {
+	if !i.HasNext() {
+		return nil, nil
+	}
+	key, val := i.keys[i.pos], i.vals[i.pos]
+	i.pos++
+
+	return key, val
+}
+
+// Err возвращает ошибку чтения, прервавшую обход.
+func (i *Iterator) Err() error {
+	return i.err
+}
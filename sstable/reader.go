@@ -2,16 +2,28 @@ package sstable
 
 import (
 	"bufio"
-	"bytes"
+	"context"
 	"encoding/binary"
 	"errors"
+	"fmt"
+	"hash/crc32"
 	"io"
 	"io/fs"
+	"sync"
+	"sync/atomic"
 
+	"github.com/golang/snappy"
+	"github.com/wubba-com/lsm-tree/comparer"
 	"github.com/wubba-com/lsm-tree/memtable/encoder"
 )
 
-var ErrKeyNotFound = errors.New("key not found")
+var (
+	ErrKeyNotFound    = errors.New("key not found")
+	ErrCorruptedTable = errors.New("sstable: corrupted table")
+	ErrEmptyTable     = errors.New("sstable: table is empty")
+
+	ErrComparerMismatch = errors.New("sstable: table was written with a different comparer")
+)
 
 type statReaderAtCloser interface {
 	Stat() (fs.FileInfo, error)
@@ -19,24 +31,62 @@ type statReaderAtCloser interface {
 	io.Closer
 }
 
+// ReadStats подсчитывает чтения Reader с момента его открытия.
+type ReadStats struct {
+	IndexBlocksRead int
+	DataBlocksRead  int
+	BytesRead       int64
+}
+
 type Reader struct {
 	file     statReaderAtCloser
 	br       *bufio.Reader
 	buf      []byte
 	encoder  *encoder.Encoder
 	fileSize int64
+	cmp      comparer.Comparer
+
+	dataEnd     int64             // конец блоков данных
+	indexOffset int64             // смещение индексного блока
+	indexLength int               // длина индексного блока
+	props       map[string][]byte // блок свойств
+
+	indexBuf       []byte // буфер индексного блока
+	compressionBuf []byte // буфер сжатого блока данных
+	keyBuf         []byte // буфер для восстановления ключей с общим префиксом
+
+	stats ReadStats
 }
 
-func NewReader(file io.Reader) (*Reader, error) {
+// NewReader открывает *.sst, записанный с тем же cmp (comparer.Bytewise,
+// если cmp == nil). Файлы, записанные с другим comparer, отклоняются.
+func NewReader(file io.Reader, cmp comparer.Comparer) (*Reader, error) {
 	r := &Reader{}
 	r.file, _ = file.(statReaderAtCloser)
 	r.br = bufio.NewReader(file)
 	r.buf = make([]byte, 0, maxBlockSize)
+	r.cmp = cmp
+	if r.cmp == nil {
+		r.cmp = comparer.Bytewise
+	}
 
 	err := r.initFileSize()
 	if err != nil {
 		return nil, err
 	}
+	err = r.readFooter()
+	if err != nil {
+		return nil, err
+	}
+
+	// Файлы старого формата всегда упорядочены побайтово.
+	name := comparer.Bytewise.Name()
+	if v, ok := r.props[propComparer]; ok {
+		name = string(v)
+	}
+	if name != r.cmp.Name() {
+		return nil, fmt.Errorf("%w: %q, expected %q", ErrComparerMismatch, name, r.cmp.Name())
+	}
 
 	return r, nil
 }
@@ -53,102 +103,197 @@ func (r *Reader) initFileSize() error {
 }
 
 // Получить весь индексный блок
-func (r *Reader) readIndexBlock(footer []byte) (*readerBlock, error) {
-	b := r.prepareBlockReader(r.buf, footer)
-
-	// Находим оффсетс которого начинается индексный блок (r.fileSize - общая длина файла, len(b.buf) - длина всего индексного блока)
-	indexOffset := r.fileSize - int64(len(b.buf))
-	_, err := r.file.ReadAt(b.buf, indexOffset)
+func (r *Reader) readIndexBlock() (*readerBlock, error) {
+	r.indexBuf = grow(r.indexBuf, r.indexLength)
+	err := r.readAt(r.indexBuf, r.indexOffset)
 	if err != nil {
 		return nil, err
 	}
-	return b, nil
+	r.stats.IndexBlocksRead++
+	if v, ok := r.props[propIndexCRC]; ok {
+		if len(v) != 4 || binary.LittleEndian.Uint32(v) != crc32.ChecksumIEEE(r.indexBuf) {
+			return nil, fmt.Errorf("%w: index block checksum mismatch", ErrCorruptedTable)
+		}
+	}
+	return r.prepareBlockReader(r.indexBuf, r.indexBuf[r.indexLength-footerSizeInBytes:])
 }
 
-func (r *Reader) prepareBlockReader(buf, footer []byte) *readerBlock {
-	// Общая длина индексного блока
+func (r *Reader) prepareBlockReader(buf, footer []byte) (*readerBlock, error) {
+	// Общая длина блока
 	indexLength := int(binary.LittleEndian.Uint32(footer[:4]))
 
-	// Кол-во ключей записаных в индексный блок
+	// Кол-во ключей записаных в блок
 	numOffsets := int(binary.LittleEndian.Uint32(footer[4:]))
 
-	// загрузка в буффер всего индексного блока
-	buf = buf[:indexLength]
+	if indexLength != len(buf) || (numOffsets+2)*4 > indexLength {
+		return nil, ErrCorruptedTable
+	}
 
-	return &readerBlock{
+	b := &readerBlock{
 		buf:        buf,
 		offsets:    buf[indexLength-(numOffsets+2)*4:],
 		numOffsets: numOffsets,
 	}
+	if err := b.check(); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrCorruptedTable, err)
+	}
+	return b, nil
 }
 
-// Считайте нижний колонтитул *.sst в предоставленный буфер.
-func (r *Reader) readFooter() ([]byte, error) {
+// Прочитать нижний колонтитул *.sst: найти индексный блок и загрузить блок свойств.
+func (r *Reader) readFooter() error {
+	if r.fileSize < footerSizeInBytes {
+		return ErrCorruptedTable
+	}
 	buf := r.buf[:footerSizeInBytes]
-	footerOffset := r.fileSize - footerSizeInBytes
-	_, err := r.file.ReadAt(buf, footerOffset)
+	err := r.readAt(buf, r.fileSize-footerSizeInBytes)
 	if err != nil {
-		return nil, err
+		return err
+	}
+
+	if binary.LittleEndian.Uint64(buf) != tableMagic {
+		// Старый формат: файл заканчивается индексным блоком, последние
+		// 8 байт которого - его длина и кол-во смещений.
+		r.indexLength = int(binary.LittleEndian.Uint32(buf[:4]))
+		r.indexOffset = r.fileSize - int64(r.indexLength)
+		r.dataEnd = r.indexOffset
+		return r.checkIndexHandle()
+	}
+
+	if r.fileSize < tableFooterSize {
+		return ErrCorruptedTable
+	}
+	buf = r.buf[:tableFooterSize]
+	err = r.readAt(buf, r.fileSize-tableFooterSize)
+	if err != nil {
+		return err
+	}
+	propsOffset := int64(binary.LittleEndian.Uint32(buf[0:]))
+	propsLength := int(binary.LittleEndian.Uint32(buf[4:]))
+	r.indexOffset = int64(binary.LittleEndian.Uint32(buf[8:]))
+	r.indexLength = int(binary.LittleEndian.Uint32(buf[12:]))
+	err = r.checkIndexHandle()
+	if err != nil {
+		return err
+	}
+	if propsLength < footerSizeInBytes || propsOffset+int64(propsLength) > r.indexOffset {
+		return ErrCorruptedTable
+	}
+	r.dataEnd = propsOffset
+
+	propsBuf := make([]byte, propsLength)
+	err = r.readAt(propsBuf, propsOffset)
+	if err != nil {
+		return err
+	}
+	props, err := r.prepareBlockReader(propsBuf, propsBuf[propsLength-footerSizeInBytes:])
+	if err != nil {
+		return err
+	}
+	r.props = make(map[string][]byte)
+	keys, vals, err := props.entries()
+	if err != nil {
+		return err
 	}
-	return buf, nil
+	for i := range keys {
+		r.props[string(keys[i])] = vals[i]
+	}
+	return nil
 }
 
-// Чтение блока данных включая его мини-индексный блок
-func (r *Reader) readDataBlock(indexEntry []byte) (*readerBlock, error) {
-	var err error
+func (r *Reader) checkIndexHandle() error {
+	if r.indexLength < footerSizeInBytes || r.indexOffset < 0 || r.indexOffset+int64(r.indexLength) > r.fileSize {
+		return ErrCorruptedTable
+	}
+	return nil
+}
+
+// Чтение блока данных включая его мини-индексный блок.
+// Блок распаковывается в dst, если его емкости достаточно.
+func (r *Reader) readDataBlock(indexEntry []byte, dst []byte) (*readerBlock, error) {
+	return r.loadDataBlock(indexEntry, dst, &r.compressionBuf, &r.stats)
+}
+
+// Как readDataBlock, но с собственным буфером сжатого блока compressed и
+// статистикой stats. Не меняет Reader, поэтому может вызываться из
+// нескольких горутин одновременно.
+func (r *Reader) loadDataBlock(indexEntry, dst []byte, compressed *[]byte, stats *ReadStats) (*readerBlock, error) {
+	if len(indexEntry) < 1 {
+		return nil, ErrCorruptedTable
+	}
 	val := r.encoder.Parse(indexEntry).Value()
-	offset := binary.LittleEndian.Uint32(val[:4]) // смещение блока данных в файле *.sst
-	length := binary.LittleEndian.Uint32(val[4:]) // длина блока данных
+	if len(val) < legacyBlockHandleSize {
+		return nil, ErrCorruptedTable
+	}
+	offset := binary.LittleEndian.Uint32(val[:4])  // смещение блока данных в файле *.sst
+	length := binary.LittleEndian.Uint32(val[4:8]) // длина блока данных
+	if int64(offset)+int64(length) > r.dataEnd {
+		return nil, ErrCorruptedTable
+	}
 
-	// Выделяем буффер под блок данных
-	buf := r.buf[:length]
+	// Выделяем буффер под сжатый блок данных
+	*compressed = grow(*compressed, int(length))
 
 	// Загружаем блок данных в память
-	_, err = r.file.ReadAt(buf, int64(offset))
+	n, err := r.file.ReadAt(*compressed, int64(offset))
+	stats.BytesRead += int64(n)
 	if err != nil {
 		return nil, err
 	}
-	b := r.prepareBlockReader(buf, buf[len(buf)-footerSizeInBytes:])
-
-	return b, nil
+	stats.DataBlocksRead++
+	if len(val) >= blockHandleSize && binary.LittleEndian.Uint32(val[8:]) != crc32.ChecksumIEEE(*compressed) {
+		return nil, fmt.Errorf("%w: data block checksum mismatch at offset %d", ErrCorruptedTable, offset)
+	}
+	buf, err := snappy.Decode(dst[:cap(dst)], *compressed)
+	if err != nil {
+		return nil, err
+	}
+	if len(buf) < footerSizeInBytes {
+		return nil, ErrCorruptedTable
+	}
 
-	//return buf, nil
+	return r.prepareBlockReader(buf, buf[len(buf)-footerSizeInBytes:])
 }
 
-func (r *Reader) binarySearch(searchKey []byte) (*encoder.EncodedValue, error) {
-	// Загрузить нижний колонтитул в память.
-	footer, err := r.readFooter()
-	if err != nil {
+func (r *Reader) binarySearch(ctx context.Context, searchKey []byte) (*encoder.EncodedValue, error) {
+	if err := ctx.Err(); err != nil {
 		return nil, err
 	}
-
 	// Загрузить индексный блок в память.
-	idxBlock, err := r.readIndexBlock(footer)
+	idxBlock, err := r.readIndexBlock()
 	if err != nil {
 		return nil, err
 	}
 	// Поиск в индексном блоке блока данных.
-	pos := idxBlock.search(searchKey, moveUpWhenKeyGT)
+	pos := idxBlock.search(r.cmp, searchKey, moveUpWhenKeyGT)
 	if pos >= idxBlock.numOffsets {
 		// ключ поиска больше, чем самый большой ключ в текущем *.sst
 		return nil, ErrKeyNotFound
 	}
 	indexEntry := idxBlock.readValAt(pos)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
 
 	// Загрузить блок данных в память.
-	blockData, err := r.readDataBlock(indexEntry)
+	blockData, err := r.readDataBlock(indexEntry, r.buf)
 	if err != nil {
 		return nil, err
 	}
+	r.buf = blockData.buf[:0]
 
+	return r.searchDataBlock(blockData, searchKey)
+}
+
+// Поиск ключа в загруженном блоке данных.
+func (r *Reader) searchDataBlock(blockData *readerBlock, searchKey []byte) (*encoder.EncodedValue, error) {
 	// тут мы вернем оффсет у которого ключу будут > ищущего ключа
-	offset := blockData.search(searchKey, moveUpWhenKeyGTE)
+	offset := blockData.search(r.cmp, searchKey, moveUpWhenKeyGTE)
 	if offset <= 0 {
 		return nil, ErrKeyNotFound
 	}
 
-	chunkStart := blockData.readOffsetAt(offset - 1)
-	chunkEnd := blockData.readOffsetAt(offset)
+	chunkStart, chunkEnd := blockData.chunkBounds(offset - 1)
 	chunk := blockData.buf[chunkStart:chunkEnd]
 
 	return r.sequentialSearchChunk(chunk, searchKey)
@@ -156,22 +301,25 @@ func (r *Reader) binarySearch(searchKey []byte) (*encoder.EncodedValue, error) {
 
 func (r *Reader) sequentialSearchChunk(chunk []byte, searchKey []byte) (*encoder.EncodedValue, error) {
 	var offset int
-	for {
-		var keyLen, valLen uint64
-		var n int
-		keyLen, n = binary.Uvarint(chunk[offset:])
-		if n <= 0 {
-			break // EOF
+	var prefixKey []byte
+	for offset < len(chunk) {
+		sharedLen, keySuffix, val, n := decodeEntry(chunk[offset:])
+		if n <= 0 || len(val) < 1 {
+			return nil, fmt.Errorf("%w: entry at offset %d cannot be decoded", ErrCorruptedTable, offset)
 		}
 		offset += n
-		valLen, n = binary.Uvarint(chunk[offset:])
-		offset += n
-		key := r.buf[:keyLen]
-		copy(key[:], chunk[offset:offset+int(keyLen)])
-		offset += int(keyLen)
-		val := chunk[offset : offset+int(valLen)]
-		offset += int(valLen)
-		cmp := bytes.Compare(searchKey, key)
+
+		// Первый ключ фрагмента хранится целиком, остальные - относительно него.
+		key := keySuffix
+		if prefixKey == nil {
+			prefixKey = keySuffix
+		} else if sharedLen > len(prefixKey) {
+			return nil, fmt.Errorf("%w: entry at offset %d shares more than its prefix key", ErrCorruptedTable, offset)
+		} else {
+			key = append(append(r.keyBuf[:0], prefixKey[:sharedLen]...), keySuffix...)
+			r.keyBuf = key
+		}
+		cmp := r.cmp.Compare(searchKey, key)
 		if cmp == 0 {
 			return r.encoder.Parse(val), nil
 		}
@@ -182,8 +330,244 @@ func (r *Reader) sequentialSearchChunk(chunk []byte, searchKey []byte) (*encoder
 	return nil, ErrKeyNotFound
 }
 
+// KeyRange возвращает наименьший и наибольший ключи *.sst файла.
+func (r *Reader) KeyRange() (smallest, largest []byte, err error) {
+	idxBlock, err := r.readIndexBlock()
+	if err != nil {
+		return nil, nil, err
+	}
+	if idxBlock.numOffsets == 0 {
+		return nil, nil, ErrEmptyTable
+	}
+
+	// Наименьший ключ - первый ключ первого блока данных.
+	first, err := r.readDataBlock(idxBlock.readValAt(0), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, _, err := first.entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, ErrCorruptedTable
+	}
+	smallest = keys[0]
+
+	// Наибольший ключ - последний ключ последнего блока данных.
+	last, err := r.readDataBlock(idxBlock.readValAt(idxBlock.numOffsets-1), nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	keys, _, err = last.entries()
+	if err != nil {
+		return nil, nil, err
+	}
+	if len(keys) == 0 {
+		return nil, nil, ErrCorruptedTable
+	}
+	largest = keys[len(keys)-1]
+
+	return smallest, largest, nil
+}
+
 func (r *Reader) Get(searchKey []byte) (*encoder.EncodedValue, error) {
-	return r.binarySearch(searchKey)
+	return r.binarySearch(context.Background(), searchKey)
+}
+
+// GetContext работает как Get, но прерывается с ctx.Err() перед чтением
+// каждого блока, если ctx отменен.
+func (r *Reader) GetContext(ctx context.Context, searchKey []byte) (*encoder.EncodedValue, error) {
+	return r.binarySearch(ctx, searchKey)
+}
+
+// MultiGet ищет ключи keys, отсортированные по возрастанию. Элемент i
+// результата - значение keys[i] или nil, если ключа в *.sst нет. Индексный
+// блок читается один раз, и каждый нужный блок данных читается и
+// распаковывается один раз для всех его ключей. До parallelism блоков данных
+// читаются одновременно. ctx проверяется перед чтением каждого блока.
+func (r *Reader) MultiGet(ctx context.Context, keys [][]byte, parallelism int) ([]*encoder.EncodedValue, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	idxBlock, err := r.readIndexBlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// Сгруппировать ключи по блокам данных. Ключи отсортированы, поэтому
+	// ключи одного блока идут подряд.
+	type blockLookup struct {
+		indexEntry []byte
+		keys       []int // номера ключей в keys
+		block      *readerBlock
+	}
+	var lookups []*blockLookup
+	lastPos := -1
+	for i, key := range keys {
+		pos := idxBlock.search(r.cmp, key, moveUpWhenKeyGT)
+		if pos >= idxBlock.numOffsets {
+			break // этот и следующие ключи больше самого большого ключа *.sst
+		}
+		if pos != lastPos {
+			lookups = append(lookups, &blockLookup{indexEntry: idxBlock.readValAt(pos)})
+			lastPos = pos
+		}
+		l := lookups[len(lookups)-1]
+		l.keys = append(l.keys, i)
+	}
+
+	// Загрузить блоки данных: у каждой горутины свой буфер сжатого блока и
+	// своя статистика.
+	parallelism = max(1, min(parallelism, len(lookups)))
+	stats := make([]ReadStats, parallelism)
+	errs := make([]error, parallelism)
+	var next atomic.Int64
+	var wg sync.WaitGroup
+	for w := 0; w < parallelism; w++ {
+		wg.Add(1)
+		go func(w int) {
+			defer wg.Done()
+			var compressed []byte
+			for {
+				i := int(next.Add(1)) - 1
+				if i >= len(lookups) {
+					return
+				}
+				if errs[w] = ctx.Err(); errs[w] != nil {
+					return
+				}
+				lookups[i].block, errs[w] = r.loadDataBlock(lookups[i].indexEntry, nil, &compressed, &stats[w])
+				if errs[w] != nil {
+					return
+				}
+			}
+		}(w)
+	}
+	wg.Wait()
+	for w := range stats {
+		r.stats.DataBlocksRead += stats[w].DataBlocksRead
+		r.stats.BytesRead += stats[w].BytesRead
+	}
+	for _, err := range errs {
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	vals := make([]*encoder.EncodedValue, len(keys))
+	for _, l := range lookups {
+		for _, i := range l.keys {
+			v, err := r.searchDataBlock(l.block, keys[i])
+			if err == nil {
+				vals[i] = v
+			} else if !errors.Is(err, ErrKeyNotFound) {
+				return nil, err
+			}
+		}
+	}
+	return vals, nil
+}
+
+// NumEntries возвращает кол-во записей *.sst из блока свойств. ok == false
+// для файлов, записанных до появления этого свойства.
+func (r *Reader) NumEntries() (n int64, ok bool) {
+	v, ok := r.props[propNumEntries]
+	if !ok {
+		return 0, false
+	}
+	u, k := binary.Uvarint(v)
+	if k <= 0 {
+		return 0, false
+	}
+	return int64(u), true
+}
+
+// LogNum возвращает номер WAL, сохраненный Writer.SetLogNum. ok == false,
+// если он не был сохранен.
+func (r *Reader) LogNum() (n int, ok bool) {
+	return r.intProperty(propLogNum)
+}
+
+// FlushNum возвращает номер, сохраненный Writer.SetFlushNum. ok == false,
+// если он не был сохранен.
+func (r *Reader) FlushNum() (n int, ok bool) {
+	return r.intProperty(propFlushNum)
+}
+
+func (r *Reader) intProperty(name string) (int, bool) {
+	v, ok := r.props[name]
+	if !ok {
+		return 0, false
+	}
+	u, k := binary.Uvarint(v)
+	if k <= 0 {
+		return 0, false
+	}
+	return int(u), true
+}
+
+// ApproximateRange оценивает, сколько байт файла и сколько записей
+// приходится на ключи из [start, end], по смещениям и длинам блоков данных
+// в индексном блоке, не читая сами блоки. nil вместо start или end снимает
+// границу с этой стороны. Оценка больше точного значения не более чем на
+// блок данных с каждой стороны. Записи оцениваются пропорционально числу
+// блоков, и для файлов без свойства num_entries их число равно 0.
+func (r *Reader) ApproximateRange(start, end []byte) (size, entries int64, err error) {
+	idxBlock, err := r.readIndexBlock()
+	if err != nil {
+		return 0, 0, err
+	}
+	if idxBlock.numOffsets == 0 {
+		return 0, 0, nil
+	}
+	// Ключ блока в индексе не меньше всех ключей блока и меньше ключей
+	// следующего блока.
+	first, last := 0, idxBlock.numOffsets-1
+	if start != nil {
+		first = idxBlock.search(r.cmp, start, moveUpWhenKeyGT)
+	}
+	if end != nil {
+		last = min(last, idxBlock.search(r.cmp, end, moveUpWhenKeyGT))
+	}
+	if first > last {
+		return 0, 0, nil
+	}
+	for pos := first; pos <= last; pos++ {
+		indexEntry := idxBlock.readValAt(pos)
+		if len(indexEntry) < 1 {
+			return 0, 0, ErrCorruptedTable
+		}
+		val := r.encoder.Parse(indexEntry).Value()
+		if len(val) < legacyBlockHandleSize {
+			return 0, 0, ErrCorruptedTable
+		}
+		size += int64(binary.LittleEndian.Uint32(val[4:])) // длина блока данных
+	}
+	if n, ok := r.NumEntries(); ok {
+		entries = n * int64(last-first+1) / int64(idxBlock.numOffsets)
+	}
+	return size, entries, nil
+}
+
+// Stats возвращает статистику чтений с момента открытия *.sst.
+func (r *Reader) Stats() ReadStats {
+	return r.stats
+}
+
+// Прочитать len(buf) байт файла начиная с off, учитывая их в статистике.
+func (r *Reader) readAt(buf []byte, off int64) error {
+	n, err := r.file.ReadAt(buf, off)
+	r.stats.BytesRead += int64(n)
+	return err
+}
+
+// Вернуть буфер длиной n, при необходимости выделив новый.
+func grow(buf []byte, n int) []byte {
+	if cap(buf) < n {
+		return make([]byte, n)
+	}
+	return buf[:n]
 }
 
 func (r *Reader) Close() error {
@@ -1,8 +1,10 @@
 package sstable
 
 import (
-	"bytes"
 	"encoding/binary"
+	"fmt"
+
+	"github.com/wubba-com/lsm-tree/comparer"
 )
 
 /*
@@ -36,8 +38,7 @@ type readerBlock struct {
 }
 
 func (b *readerBlock) readOffsetAt(pos int) int {
-	offset, _, _ := b.fetchDataFor(pos)
-	return offset
+	return int(binary.LittleEndian.Uint32(b.offsets[pos*4 : pos*4+4]))
 }
 
 func (b *readerBlock) readKeyAt(pos int) []byte {
@@ -53,36 +54,111 @@ func (b *readerBlock) readValAt(pos int) []byte {
 
 // Чтение индексного блока с крайними ключами и общей длиной блока данных (entries)
 func (b *readerBlock) fetchDataFor(pos int) (kvOffset int, key, val []byte) {
-	var keyLen, valLen uint64
-	var n int
-	kvOffset = int(binary.LittleEndian.Uint32(b.offsets[pos*4 : pos*4+4]))
-	offset := kvOffset
-	
-	_, n = binary.Uvarint(b.buf[offset:]) // sharedLen = 0
-	offset += n
-	
-	keyLen, n = binary.Uvarint(b.buf[offset:])
-	offset += n
-	
-	valLen, n = binary.Uvarint(b.buf[offset:])
-	offset += n
-	
-	key = b.buf[offset : offset+int(keyLen)]
-	offset += int(keyLen)
-	val = b.buf[offset : offset+int(valLen)]
-	
+	kvOffset = b.readOffsetAt(pos)
+	_, key, val, _ = decodeEntry(b.buf[kvOffset:]) // sharedLen = 0
+
 	return
 }
 
+// Граница, на которой заканчиваются записи и начинаются смещения блока.
+func (b *readerBlock) entriesEnd() int {
+	return len(b.buf) - len(b.offsets)
+}
+
+// Начало и конец фрагмента данных под номером pos.
+func (b *readerBlock) chunkBounds(pos int) (start, end int) {
+	start = b.readOffsetAt(pos)
+	if pos+1 < b.numOffsets {
+		return start, b.readOffsetAt(pos + 1)
+	}
+	return start, b.entriesEnd()
+}
+
+// Проверить, что смещения фрагментов возрастают и не выходят за границу
+// записей, а первая запись каждого фрагмента целиком лежит в нем. Тогда
+// readKeyAt и readValAt не выйдут за пределы блока, даже если у *.sst нет
+// контрольных сумм.
+func (b *readerBlock) check() error {
+	prev := 0
+	for pos := 0; pos < b.numOffsets; pos++ {
+		offset := b.readOffsetAt(pos)
+		if offset < prev || offset > b.entriesEnd() {
+			return fmt.Errorf("offset %d of %d is out of bounds", pos, b.numOffsets)
+		}
+		prev = offset
+	}
+	for pos := 0; pos < b.numOffsets; pos++ {
+		start, end := b.chunkBounds(pos)
+		if _, _, _, n := decodeEntry(b.buf[start:end]); n <= 0 {
+			return fmt.Errorf("entry at offset %d cannot be decoded", start)
+		}
+	}
+	return nil
+}
+
+// Разобрать все записи блока, восстанавливая ключи по префиксному ключу фрагмента.
+// На поврежденной записи возвращает ошибку и записи, разобранные до нее.
+func (b *readerBlock) entries() (keys, vals [][]byte, err error) {
+	for pos := 0; pos < b.numOffsets; pos++ {
+		start, end := b.chunkBounds(pos)
+		var prefixKey []byte
+		for offset := start; offset < end; {
+			sharedLen, keySuffix, val, n := decodeEntry(b.buf[offset:end])
+			if n <= 0 {
+				return keys, vals, fmt.Errorf("%w: entry at offset %d cannot be decoded", ErrCorruptedTable, offset)
+			}
+			offset += n
+
+			key := keySuffix
+			if prefixKey == nil {
+				prefixKey = key
+			} else if sharedLen > len(prefixKey) {
+				return keys, vals, fmt.Errorf("%w: entry at offset %d shares more than its prefix key", ErrCorruptedTable, offset)
+			} else if sharedLen > 0 {
+				key = make([]byte, 0, sharedLen+len(keySuffix))
+				key = append(key, prefixKey[:sharedLen]...)
+				key = append(key, keySuffix...)
+			}
+			keys = append(keys, key)
+			vals = append(vals, val)
+		}
+	}
+	return keys, vals, nil
+}
+
+// Разобрать одну запись вида [sharedLen][keyLen][valLen][key][val].
+// Возвращает n <= 0, если буфер закончился или запись выходит за его пределы.
+func decodeEntry(buf []byte) (sharedLen int, keySuffix, val []byte, n int) {
+	var fields [3]uint64
+	for i := range fields {
+		v, m := binary.Uvarint(buf[n:])
+		if m <= 0 {
+			return 0, nil, nil, 0
+		}
+		fields[i] = v
+		n += m
+	}
+	keyLen, valLen := fields[1], fields[2]
+	if keyLen > uint64(len(buf)-n) || valLen > uint64(len(buf)-n)-keyLen {
+		return 0, nil, nil, 0
+	}
+
+	keySuffix = buf[n : n+int(keyLen)]
+	n += int(keyLen)
+	val = buf[n : n+int(valLen)]
+	n += int(valLen)
+
+	return int(fields[0]), keySuffix, val, n
+}
+
 // По ключу в оффсетах находит номер оффсета блока данных
-func (b *readerBlock) search(searchKey []byte, condition searchCondition) int {
+func (b *readerBlock) search(cmp comparer.Comparer, searchKey []byte, condition searchCondition) int {
 	low, high := 0, b.numOffsets
 	var mid int
 	for low < high {
 		mid = (low + high) / 2
 		key := b.readKeyAt(mid)
-		cmp := bytes.Compare(searchKey, key)
-		if cmp >= int(condition) {
+		if cmp.Compare(searchKey, key) >= int(condition) {
 			low = mid + 1
 		} else {
 			high = mid
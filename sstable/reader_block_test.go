@@ -0,0 +1,78 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/wubba-com/lsm-tree/comparer"
+)
+
+// Returns a block of 8 entries in chunks of 4, the way the writer lays it out.
+// The first entry is [0, 4, 2, "key0", opKind, "v"] at offset 0, the second
+// one starts at offset 9.
+func testBlock(t *testing.T) []byte {
+	wb := newBlockWriter(4)
+	for i := 0; i < 8; i++ {
+		if _, err := wb.add([]byte(fmt.Sprintf("key%d", i)), []byte{1, 'v'}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := wb.finish(); err != nil {
+		t.Fatal(err)
+	}
+	return append([]byte(nil), wb.buf.Bytes()...)
+}
+
+func TestCorruptBlock(t *testing.T) {
+	r := &Reader{cmp: comparer.Bytewise}
+	prepare := func(buf []byte) (*readerBlock, error) {
+		return r.prepareBlockReader(buf, buf[len(buf)-footerSizeInBytes:])
+	}
+
+	b, err := prepare(testBlock(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if keys, _, err := b.entries(); err != nil || len(keys) != 8 {
+		t.Fatalf("expected 8 entries, got %d, %v", len(keys), err)
+	}
+	if _, err = r.searchDataBlock(b, []byte("key1")); err != nil {
+		t.Fatal(err)
+	}
+
+	// Tables written before checksums cannot tell corrupt lengths from
+	// valid ones, so decoding has to stay within the block.
+	for _, tc := range []struct {
+		name        string
+		corrupt     func(buf []byte)
+		failsToOpen bool
+	}{
+		{"key length of the first entry", func(buf []byte) { buf[1] = 0x7f }, true},
+		{"offset of a chunk", func(buf []byte) { buf[len(buf)-12] = 0xff }, true},
+		{"key length", func(buf []byte) { buf[10] = 0x7f }, false},
+		{"value length", func(buf []byte) { buf[11] = 0x7f }, false},
+		{"shared length", func(buf []byte) { buf[9] = 0x7f }, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			buf := testBlock(t)
+			tc.corrupt(buf)
+			b, err := prepare(buf)
+			if tc.failsToOpen {
+				if !errors.Is(err, ErrCorruptedTable) {
+					t.Fatalf("expected ErrCorruptedTable, got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if _, _, err = b.entries(); !errors.Is(err, ErrCorruptedTable) {
+				t.Fatalf("expected ErrCorruptedTable from entries, got %v", err)
+			}
+			if _, err = r.searchDataBlock(b, []byte("key2")); !errors.Is(err, ErrCorruptedTable) {
+				t.Fatalf("expected ErrCorruptedTable from a lookup, got %v", err)
+			}
+		})
+	}
+}
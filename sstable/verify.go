@@ -0,0 +1,117 @@
+package sstable
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+// Verify проверяет *.sst целиком: записи индексного блока и границы блоков
+// данных, на которые они указывают, контрольные суммы блоков, порядок ключей
+// внутри блоков и между ними, то, что ключ индексной записи не меньше ключей
+// своего блока и меньше ключей следующего, и число записей из блока свойств.
+// Нижний колонтитул и блок свойств проверяет NewReader. Возвращает все
+// найденные ошибки; каждая оборачивает ErrCorruptedTable. Контрольные суммы
+// файлов, записанных до их появления, не проверяются.
+func (r *Reader) Verify() []error {
+	errs, _ := r.walk(nil)
+	return errs
+}
+
+// Salvage передает fn по возрастанию ключей все записи, которые удается
+// прочитать из поврежденного *.sst, пропуская блоки данных, не прошедшие
+// проверку, и записи не по порядку. Возвращает найденные ошибки, как Verify,
+// и ошибку fn, на которой чтение прекращается.
+func (r *Reader) Salvage(fn func(key, val []byte) error) ([]error, error) {
+	return r.walk(fn)
+}
+
+// Обойти *.sst, проверяя его, как Verify, и передать visit, если он задан,
+// записи из прочитанных блоков данных.
+func (r *Reader) walk(visit func(key, val []byte) error) ([]error, error) {
+	var errs []error
+	report := func(format string, args ...any) {
+		errs = append(errs, fmt.Errorf("%w: %s", ErrCorruptedTable, fmt.Sprintf(format, args...)))
+	}
+
+	idxBlock, err := r.readIndexBlock()
+	if err != nil {
+		return append(errs, err), nil
+	}
+	// Индексный блок должен пережить чтение блоков данных.
+	r.indexBuf = nil
+
+	var prevIndexKey, prevKey, lastVisited []byte
+	var nextOffset int64
+	var numEntries int64
+	allRead := true // все ли блоки данных удалось прочитать
+	for pos := 0; pos < idxBlock.numOffsets; pos++ {
+		_, indexKey, val, n := decodeEntry(idxBlock.buf[idxBlock.readOffsetAt(pos):idxBlock.entriesEnd()])
+		if n <= 0 || len(val) < 1 {
+			report("index entry %d cannot be decoded", pos)
+			return errs, nil
+		}
+		if prevIndexKey != nil && r.cmp.Compare(prevIndexKey, indexKey) >= 0 {
+			report("index entry %d is out of order", pos)
+		}
+
+		handle := r.encoder.Parse(val).Value()
+		if len(handle) != blockHandleSize && len(handle) != legacyBlockHandleSize {
+			report("index entry %d has a block handle of %d bytes", pos, len(handle))
+			allRead = false
+			prevIndexKey = indexKey
+			continue
+		}
+		offset := int64(binary.LittleEndian.Uint32(handle[:4]))
+		length := int64(binary.LittleEndian.Uint32(handle[4:8]))
+		if offset != nextOffset || offset+length > r.dataEnd {
+			report("data block %d at [%d, %d) does not follow the previous block ending at %d within %d bytes of data", pos, offset, offset+length, nextOffset, r.dataEnd)
+		}
+		nextOffset = offset + length
+
+		block, err := r.readDataBlock(val, nil)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("data block %d: %w", pos, err))
+			allRead = false
+			prevIndexKey = indexKey
+			continue
+		}
+		keys, vals, err := block.entries()
+		if err != nil {
+			errs = append(errs, fmt.Errorf("data block %d: %w", pos, err))
+			allRead = false
+		}
+		if len(keys) == 0 {
+			report("data block %d is empty", pos)
+		}
+		for i, key := range keys {
+			if prevKey != nil && r.cmp.Compare(prevKey, key) >= 0 {
+				report("data block %d: key %d is out of order", pos, i)
+			}
+			prevKey = key
+			if visit == nil || lastVisited != nil && r.cmp.Compare(lastVisited, key) >= 0 {
+				continue
+			}
+			if err := visit(key, vals[i]); err != nil {
+				return errs, err
+			}
+			lastVisited = key
+		}
+		if len(keys) > 0 {
+			if r.cmp.Compare(keys[len(keys)-1], indexKey) > 0 {
+				report("data block %d: keys exceed the index key of the block", pos)
+			}
+			if prevIndexKey != nil && r.cmp.Compare(keys[0], prevIndexKey) <= 0 {
+				report("data block %d: keys do not exceed the index key of the previous block", pos)
+			}
+		}
+		numEntries += int64(len(keys))
+		prevIndexKey = indexKey
+	}
+	if nextOffset != r.dataEnd {
+		report("data blocks end at %d, expected %d", nextOffset, r.dataEnd)
+	}
+	if n, ok := r.NumEntries(); ok && allRead && n != numEntries {
+		report("%d entries, expected %d", numEntries, n)
+	}
+	return errs, nil
+}
@@ -3,10 +3,13 @@ package sstable
 import (
 	"bufio"
 	"encoding/binary"
+	"errors"
+	"hash/crc32"
 	"io"
 	"math"
 
 	"github.com/golang/snappy"
+	"github.com/wubba-com/lsm-tree/comparer"
 	"github.com/wubba-com/lsm-tree/memtable"
 	"github.com/wubba-com/lsm-tree/memtable/encoder"
 )
@@ -21,6 +24,35 @@ const (
 	footerSizeInBytes = 1 << 3
 )
 
+/*
+Структура *.sst файла:
+
+	[блоки данных][блок свойств][индексный блок][нижний колонтитул]
+
+Нижний колонтитул: [смещение и длина блока свойств][смещение и длина индексного блока][tableMagic].
+Файлы старого формата заканчиваются индексным блоком, без блока свойств и колонтитула.
+Запись индексного блока: [смещение][длина][crc32 сжатого блока данных]; в файлах,
+записанных до появления контрольных сумм, crc32 нет.
+*/
+const (
+	tableFooterSize        = 24
+	tableMagic      uint64 = 0x6c736d2d74726565 // "lsm-tree"
+)
+
+const (
+	blockHandleSize       = 12 // значение записи индексного блока
+	legacyBlockHandleSize = 8  // то же без контрольной суммы
+)
+
+// Ключи блока свойств.
+const (
+	propComparer   = "comparer"
+	propNumEntries = "num_entries" // uvarint
+	propIndexCRC   = "index_crc"   // crc32 индексного блока, little-endian
+	propLogNum     = "log_num"     // uvarint, см. Writer.SetLogNum
+	propFlushNum   = "flush_num"   // uvarint, см. Writer.SetFlushNum
+)
+
 const (
 	dataBlockChunkSize  = 1 << 4
 	indexBlockChunkSize = 1 << 0
@@ -33,6 +65,7 @@ type Writer struct {
 	bw             *bufio.Writer
 	buf            []byte
 	compressionBuf []byte
+	cmp            comparer.Comparer
 	// offsets    []uint32
 	// nextOffset uint32
 
@@ -43,13 +76,33 @@ type Writer struct {
 	offset       int    // offset of current data block.
 	bytesWritten int    // bytesWritten to current data block.
 	lastKey      []byte // lastKey in current data block
+	numEntries   int    // кол-во записей в файле
+
+	// Индексная запись сброшенного блока данных ждет первый ключ следующего
+	// блока, чтобы выбрать короткий разделитель между ними.
+	pendingIndexEntry bool
+	pendingOffset     int
+	pendingLength     int
+	pendingChecksum   uint32
+	finished          bool
+
+	logNum   int // номер WAL для свойства log_num, 0, если не задан
+	flushNum int // номер для свойства flush_num, 0, если не задан
 }
 
-func NewWriter(file io.Writer) *Writer {
+var ErrKeysOutOfOrder = errors.New("sstable: keys must be added in ascending order")
+
+// NewWriter создает Writer, упорядочивающий ключи с помощью cmp
+// (comparer.Bytewise, если cmp == nil).
+func NewWriter(file io.Writer, cmp comparer.Comparer) *Writer {
 	w := &Writer{}
 	bw := bufio.NewWriter(file)
 	w.file, w.bw = file.(syncCloser), bw
 	w.buf = make([]byte, 0, 1<<10)
+	w.cmp = cmp
+	if w.cmp == nil {
+		w.cmp = comparer.Bytewise
+	}
 
 	w.dataBlock, w.indexBlock = newBlockWriter(dataBlockChunkSize), newBlockWriter(indexBlockChunkSize)
 
@@ -59,14 +112,16 @@ func NewWriter(file io.Writer) *Writer {
 /*
 addIndexEntry для добавления начального смещения каждого добавленного блока данных к offsets фрагменту и вычисления смещения следующего добавляемого блока данных (сохранения его в nextOffset).
 */
-func (w *Writer) addIndexEntry() error {
-	buf := w.buf[:8]
-	binary.LittleEndian.PutUint32(buf[:4], uint32(w.offset))              // data block offset
-	binary.LittleEndian.PutUint32(buf[4:], uint32(len(w.compressionBuf))) // data block length
-	_, err := w.indexBlock.add(w.lastKey, w.encoder.Encode(encoder.OpKindSet, buf))
+func (w *Writer) addIndexEntry(key []byte) error {
+	buf := w.buf[:blockHandleSize]
+	binary.LittleEndian.PutUint32(buf[:4], uint32(w.pendingOffset))   // data block offset
+	binary.LittleEndian.PutUint32(buf[4:8], uint32(w.pendingLength))  // data block length
+	binary.LittleEndian.PutUint32(buf[8:], uint32(w.pendingChecksum)) // crc32 of the compressed block
+	_, err := w.indexBlock.add(key, w.encoder.Encode(encoder.OpKindSet, buf))
 	if err != nil {
 		return err
 	}
+	w.pendingIndexEntry = false
 	return nil
 }
 
@@ -85,46 +140,160 @@ func (w *Writer) flushDataBlock() error {
 	if err != nil {
 		return err
 	}
-	err = w.addIndexEntry()
+	w.pendingIndexEntry = true
+	w.pendingOffset, w.pendingLength = w.offset, len(w.compressionBuf)
+	w.pendingChecksum = crc32.ChecksumIEEE(w.compressionBuf)
+	w.offset += len(w.compressionBuf)
+	w.bytesWritten = 0
+	return nil
+}
+
+// Add добавляет запись в *.sst. Ключи должны добавляться по возрастанию,
+// val - закодированное значение (см. encoder.Encoder).
+func (w *Writer) Add(key, val []byte) error {
+	if w.numEntries > 0 && w.cmp.Compare(w.lastKey, key) >= 0 {
+		return ErrKeysOutOfOrder
+	}
+	if w.pendingIndexEntry {
+		// Ключ индексной записи: lastKey <= separator < key.
+		err := w.addIndexEntry(w.cmp.Separator(nil, w.lastKey, key))
+		if err != nil {
+			return err
+		}
+	}
+	n, err := w.dataBlock.add(key, val)
 	if err != nil {
 		return err
 	}
-	w.offset += len(w.compressionBuf)
-	w.bytesWritten = 0
+	w.bytesWritten += n
+	w.lastKey = append(w.lastKey[:0], key...)
+	w.numEntries++
+
+	if w.bytesWritten > blockFlushThreshold {
+		return w.flushDataBlock()
+	}
 	return nil
 }
 
+// EstimatedSize возвращает примерный размер *.sst: сжатые сброшенные блоки
+// данных и несжатый текущий блок данных.
+func (w *Writer) EstimatedSize() int {
+	return w.offset + w.bytesWritten
+}
+
 func (w *Writer) Write(m *memtable.Memtable) error {
 	i := m.Iterator()
 	for i.HasNext() {
 		key, val := i.Next()
-		n, err := w.dataBlock.add(key, val)
+		err := w.Add(key, val)
 		if err != nil {
 			return err
 		}
-		w.bytesWritten += n
-		w.lastKey = key
-
-		if w.bytesWritten > blockFlushThreshold {
-			err = w.flushDataBlock()
-			if err != nil {
-				return err
-			}
-		}
 	}
+	return w.finish()
+}
+
+// Завершает *.sst: сбрасывает последний блок данных и дописывает блок
+// свойств, индексный блок и нижний колонтитул.
+func (w *Writer) finish() error {
+	if w.finished {
+		return nil
+	}
+	w.finished = true
+
 	err := w.flushDataBlock()
 	if err != nil {
 		return err
 	}
+	if w.pendingIndexEntry {
+		err = w.addIndexEntry(w.cmp.Successor(nil, w.lastKey))
+		if err != nil {
+			return err
+		}
+	}
+
+	// Блок свойств хранит контрольную сумму индексного блока, поэтому
+	// индексный блок завершается первым.
 	err = w.indexBlock.finish()
 	if err != nil {
 		return err
 	}
+	propsOffset := w.offset
+	propsLength, err := w.writeProperties(crc32.ChecksumIEEE(w.indexBlock.buf.Bytes()))
+	if err != nil {
+		return err
+	}
+
+	indexOffset := propsOffset + propsLength
+	indexLength := w.indexBlock.buf.Len()
 	_, err = w.bw.ReadFrom(w.indexBlock.buf)
 	if err != nil {
 		return err
 	}
-	return nil
+
+	footer := w.buf[:tableFooterSize]
+	binary.LittleEndian.PutUint32(footer[0:], uint32(propsOffset))
+	binary.LittleEndian.PutUint32(footer[4:], uint32(propsLength))
+	binary.LittleEndian.PutUint32(footer[8:], uint32(indexOffset))
+	binary.LittleEndian.PutUint32(footer[12:], uint32(indexLength))
+	binary.LittleEndian.PutUint64(footer[16:], tableMagic)
+	_, err = w.bw.Write(footer)
+
+	return err
+}
+
+// SetLogNum сохраняет в блоке свойств номер WAL, все записи из WAL с
+// меньшими номерами которого уже есть в этом или более старых *.sst. По нему
+// БД восстанавливается без манифеста. Вызывается до Close.
+func (w *Writer) SetLogNum(n int) {
+	w.logNum = n
+}
+
+// SetFlushNum сохраняет в блоке свойств номер, упорядочивающий *.sst по
+// свежести данных: номер *.sst, в который сброшены самые новые из его
+// записей. По нему упорядочивается уровень 0 при восстановлении БД без
+// манифеста. Вызывается до Close.
+func (w *Writer) SetFlushNum(n int) {
+	w.flushNum = n
+}
+
+// Записывает блок свойств *.sst и возвращает его длину.
+func (w *Writer) writeProperties(indexChecksum uint32) (int, error) {
+	props := newBlockWriter(indexBlockChunkSize)
+	_, err := props.add([]byte(propComparer), []byte(w.cmp.Name()))
+	if err != nil {
+		return 0, err
+	}
+	if w.flushNum > 0 {
+		_, err = props.add([]byte(propFlushNum), binary.AppendUvarint(nil, uint64(w.flushNum)))
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = props.add([]byte(propIndexCRC), binary.LittleEndian.AppendUint32(nil, indexChecksum))
+	if err != nil {
+		return 0, err
+	}
+	if w.logNum > 0 {
+		_, err = props.add([]byte(propLogNum), binary.AppendUvarint(nil, uint64(w.logNum)))
+		if err != nil {
+			return 0, err
+		}
+	}
+	_, err = props.add([]byte(propNumEntries), binary.AppendUvarint(nil, uint64(w.numEntries)))
+	if err != nil {
+		return 0, err
+	}
+	err = props.finish()
+	if err != nil {
+		return 0, err
+	}
+	n, err := w.bw.ReadFrom(props.buf)
+	if err != nil {
+		return 0, err
+	}
+	w.offset += int(n)
+	return int(n), nil
 }
 
 // func (w *Writer) Write(m *memtable.Memtable) error {
@@ -144,24 +313,23 @@ func (w *Writer) Write(m *memtable.Memtable) error {
 // 	return nil
 // }
 
+// Close дописывает индекс и футер и закрывает файл. Файл закрывается и
+// при ошибке записи, обе ошибки возвращаются вместе.
 func (w *Writer) Close() error {
+	err := w.finish()
+
 	// Flush any remaining data from the buffer.
-	err := w.bw.Flush()
-	if err != nil {
-		return err
+	if err == nil {
+		err = w.bw.Flush()
 	}
 
 	// Force OS to flush its I/O buffers and write data to disk.
-	err = w.file.Sync()
-	if err != nil {
-		return err
+	if err == nil {
+		err = w.file.Sync()
 	}
 
 	// Close the file.
-	err = w.file.Close()
-	if err != nil {
-		return err
-	}
+	err = errors.Join(err, w.file.Close())
 
 	w.bw = nil
 	w.file = nil
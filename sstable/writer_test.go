@@ -0,0 +1,38 @@
+package sstable
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// failingFile fails every write, like a full disk.
+type failingFile struct {
+	closed bool
+}
+
+func (f *failingFile) Write(p []byte) (int, error) { return 0, errNoSpace }
+func (f *failingFile) Sync() error                 { return nil }
+
+func (f *failingFile) Close() error {
+	f.closed = true
+	return nil
+}
+
+var errNoSpace = errors.New("no space left on device")
+
+func TestWriterClosesFileOnError(t *testing.T) {
+	f := &failingFile{}
+	w := NewWriter(f, nil)
+	for i := 0; i < 1000; i++ {
+		if err := w.Add([]byte(fmt.Sprintf("key%04d", i)), []byte("v")); err != nil {
+			break
+		}
+	}
+	if err := w.Close(); !errors.Is(err, errNoSpace) {
+		t.Fatalf("expected %v, got %v", errNoSpace, err)
+	}
+	if !f.closed {
+		t.Fatal("expected the file to be closed")
+	}
+}